@@ -0,0 +1,26 @@
+package lease
+
+type GrantResult struct {
+	LeaseID  string
+	OK       bool
+	Revision int64
+	Err      error
+}
+
+type KeepAliveResult struct {
+	LeaseID  string
+	ExpireAt Timestamp
+	Err      error
+}
+
+type RevokeResult struct {
+	LeaseID string
+	OK      bool
+	Err     error
+}
+
+type LeaseResult struct {
+	LeaseID string
+	Lease   *Lease
+	Err     error
+}
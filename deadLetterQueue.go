@@ -0,0 +1,221 @@
+package lease
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/bcowtech/lib-redis-lease/internal/helper"
+	redis "github.com/go-redis/redis/v7"
+)
+
+const (
+	DEAD_LETTER_FIELD_SOURCE     string = "dlq_source"
+	DEAD_LETTER_FIELD_SOURCE_ID  string = "dlq_source_id"
+	DEAD_LETTER_FIELD_GROUP      string = "dlq_group"
+	DEAD_LETTER_FIELD_ERROR      string = "dlq_error"
+	DEAD_LETTER_FIELD_DELIVERIES string = "dlq_deliveries"
+	DEAD_LETTER_FIELD_TIMESTAMP  string = "dlq_timestamp"
+)
+
+// requeueScript moves the dead letter ARGV[1] of KEYS[2] back to the source
+// sink KEYS[1] with the fields in ARGV[2..], or returns nil if it is absent.
+var requeueScript = redis.NewScript(`
+if #redis.call('XRANGE', KEYS[2], ARGV[1], ARGV[1]) == 0 then
+	return false
+end
+local id = redis.call('XADD', KEYS[1], '*', unpack(ARGV, 2))
+redis.call('XDEL', KEYS[2], ARGV[1])
+return id
+`)
+
+var deadLetterFields = []string{
+	DEAD_LETTER_FIELD_SOURCE,
+	DEAD_LETTER_FIELD_SOURCE_ID,
+	DEAD_LETTER_FIELD_GROUP,
+	DEAD_LETTER_FIELD_ERROR,
+	DEAD_LETTER_FIELD_DELIVERIES,
+	DEAD_LETTER_FIELD_TIMESTAMP,
+}
+
+// DeadLetter is an event moved to a dead-letter sink by a Watcher. Values
+// holds the fields of the original stream entry.
+type DeadLetter struct {
+	ID         string
+	Source     string
+	SourceID   string
+	Group      string
+	Error      string
+	Deliveries int64
+	Timestamp  Timestamp
+	Values     map[string]interface{}
+}
+
+// Event parses the original stream entry.
+func (d *DeadLetter) Event() *Event {
+	ev := &Event{Sink: d.Source}
+	fillEventFromMessage(ev, d.SourceID, d.Values)
+	return ev
+}
+
+// DeadLetterQueue reads and requeues the entries of a dead-letter sink.
+type DeadLetterQueue struct {
+	RedisOption *RedisOption
+	ClusterMode bool
+	Sink        string
+
+	client RedisClient
+}
+
+func (q *DeadLetterQueue) Init() error {
+	if len(q.Sink) == 0 {
+		logger.Panic("'Sink' cannot be an empty string")
+	}
+
+	client, err := createRedisClient(q.RedisOption, q.ClusterMode)
+	if err != nil {
+		return err
+	}
+	q.client = client
+	return nil
+}
+
+func (q *DeadLetterQueue) Close() error {
+	if q.client != nil {
+		return q.client.Close()
+	}
+	return nil
+}
+
+// List returns up to count entries from the ID start on; "-" starts from the
+// oldest entry.
+func (q *DeadLetterQueue) List(start string, count int64) ([]*DeadLetter, error) {
+	return q.ListContext(context.Background(), start, count)
+}
+
+func (q *DeadLetterQueue) ListContext(ctx context.Context, start string, count int64) ([]*DeadLetter, error) {
+	if len(start) == 0 {
+		start = "-"
+	}
+
+	messages, err := helper.WithContext(q.client, ctx).XRangeN(q.Sink, start, "+", count).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	letters := make([]*DeadLetter, len(messages))
+	for i, message := range messages {
+		letters[i] = parseDeadLetter(message)
+	}
+	return letters, nil
+}
+
+// Get returns the entry of the ID, or nil if it is absent.
+func (q *DeadLetterQueue) Get(id string) (*DeadLetter, error) {
+	return q.GetContext(context.Background(), id)
+}
+
+func (q *DeadLetterQueue) GetContext(ctx context.Context, id string) (*DeadLetter, error) {
+	messages, err := helper.WithContext(q.client, ctx).XRangeN(q.Sink, id, id, 1).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(messages) == 0 {
+		return nil, nil
+	}
+	return parseDeadLetter(messages[0]), nil
+}
+
+// Requeue appends the original entry back to its source sink and removes it
+// from the dead-letter sink, atomically. It returns the ID of the new entry in
+// the source sink, or an empty string if the entry is absent. The new entry
+// is delivered to every consumer group of the source sink again, not only to
+// the Group which dead-lettered it. In cluster mode the source sink and the
+// dead-letter sink must share a hash tag.
+func (q *DeadLetterQueue) Requeue(id string) (string, error) {
+	return q.RequeueContext(context.Background(), id)
+}
+
+func (q *DeadLetterQueue) RequeueContext(ctx context.Context, id string) (string, error) {
+	letter, err := q.GetContext(ctx, id)
+	if err != nil || letter == nil {
+		return "", err
+	}
+
+	args := make([]interface{}, 0, 1+2*len(letter.Values))
+	args = append(args, id)
+	for k, v := range letter.Values {
+		args = append(args, k, v)
+	}
+
+	client := helper.WithContext(q.client, ctx)
+	reply, err := requeueScript.Run(client, []string{letter.Source, q.Sink}, args...).Text()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return reply, err
+}
+
+// Remove deletes the entries and returns the number of deleted ones.
+func (q *DeadLetterQueue) Remove(ids ...string) (int64, error) {
+	return q.RemoveContext(context.Background(), ids...)
+}
+
+func (q *DeadLetterQueue) RemoveContext(ctx context.Context, ids ...string) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	return helper.WithContext(q.client, ctx).XDel(q.Sink, ids...).Result()
+}
+
+// deadLetter appends the message to the DeadLetterSink with the cause of the
+// failure. The message is acknowledged by the caller afterwards, so it may be
+// appended twice if the Watcher dies in between.
+func (w *Watcher) deadLetter(ev *Event, message *XMessage, deliveries int64, cause error) error {
+	values := make(map[string]interface{}, len(message.Values)+len(deadLetterFields))
+	for k, v := range message.Values {
+		values[k] = v
+	}
+	var reason string
+	if cause != nil {
+		reason = cause.Error()
+	}
+	values[DEAD_LETTER_FIELD_SOURCE] = ev.Sink
+	values[DEAD_LETTER_FIELD_SOURCE_ID] = message.ID
+	values[DEAD_LETTER_FIELD_GROUP] = w.Group
+	values[DEAD_LETTER_FIELD_ERROR] = reason
+	values[DEAD_LETTER_FIELD_DELIVERIES] = deliveries
+	values[DEAD_LETTER_FIELD_TIMESTAMP] = time.Now().UnixNano() / int64(time.Millisecond)
+
+	return helper.WithContext(w.client, w.ctx).XAdd(&redis.XAddArgs{
+		Stream: w.DeadLetterSink,
+		Values: values,
+	}).Err()
+}
+
+func parseDeadLetter(message redis.XMessage) *DeadLetter {
+	letter := &DeadLetter{
+		ID:     message.ID,
+		Values: make(map[string]interface{}, len(message.Values)),
+	}
+	for k, v := range message.Values {
+		letter.Values[k] = v
+	}
+
+	str := func(name string) string {
+		v, _ := letter.Values[name].(string)
+		delete(letter.Values, name)
+		return v
+	}
+	letter.Source = str(DEAD_LETTER_FIELD_SOURCE)
+	letter.SourceID = str(DEAD_LETTER_FIELD_SOURCE_ID)
+	letter.Group = str(DEAD_LETTER_FIELD_GROUP)
+	letter.Error = str(DEAD_LETTER_FIELD_ERROR)
+	if v, err := strconv.ParseInt(str(DEAD_LETTER_FIELD_DELIVERIES), 10, 64); err == nil {
+		letter.Deliveries = v
+	}
+	if v, err := strconv.ParseInt(str(DEAD_LETTER_FIELD_TIMESTAMP), 10, 64); err == nil {
+		letter.Timestamp = Timestamp(v)
+	}
+	return letter
+}
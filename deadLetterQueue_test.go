@@ -0,0 +1,180 @@
+package lease
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	redis "github.com/go-redis/redis/v7"
+)
+
+// createTestDeadLetterQueue connects to REDIS_SERVER, or skips the test if
+// it is not set.
+func createTestDeadLetterQueue(t *testing.T, sink string) *DeadLetterQueue {
+	addr := os.Getenv("REDIS_SERVER")
+	if len(addr) == 0 {
+		t.Skip("REDIS_SERVER is not set")
+	}
+
+	q := &DeadLetterQueue{
+		RedisOption: &RedisOption{Addrs: []string{addr}},
+		Sink:        sink,
+	}
+	if err := q.Init(); err != nil {
+		t.Fatal(err)
+	}
+	return q
+}
+
+func TestDeadLetter_Event(t *testing.T) {
+	letter := parseDeadLetter(redis.XMessage{
+		ID: "5-0",
+		Values: map[string]interface{}{
+			"action":                     string(ACTION_EXPIRED),
+			"workspace":                  "op/watcher",
+			"lease":                      "lease-1",
+			"expire_at":                  "1631116984300",
+			DEAD_LETTER_FIELD_SOURCE:     "op/watcher/events",
+			DEAD_LETTER_FIELD_SOURCE_ID:  "1-0",
+			DEAD_LETTER_FIELD_GROUP:      "group",
+			DEAD_LETTER_FIELD_ERROR:      errHandleEvent.Error(),
+			DEAD_LETTER_FIELD_DELIVERIES: "3",
+			DEAD_LETTER_FIELD_TIMESTAMP:  "1631116984400",
+		},
+	})
+
+	if letter.ID != "5-0" ||
+		letter.Source != "op/watcher/events" ||
+		letter.SourceID != "1-0" ||
+		letter.Group != "group" ||
+		letter.Error != errHandleEvent.Error() ||
+		letter.Deliveries != 3 ||
+		letter.Timestamp != 1631116984400 {
+		t.Errorf("unexpected dead letter %+v", letter)
+	}
+	// Values keeps the fields of the original entry only
+	if len(letter.Values) != 4 {
+		t.Errorf("expect %v values, but got %v", 4, letter.Values)
+	}
+
+	ev := letter.Event()
+	if ev.Sink != "op/watcher/events" ||
+		ev.Action != ACTION_EXPIRED ||
+		ev.Workspace != "op/watcher" ||
+		ev.LeaseID != "lease-1" ||
+		ev.ExpireAt != 1631116984300 {
+		t.Errorf("unexpected event %+v", ev)
+	}
+}
+
+func TestDeadLetterQueue(t *testing.T) {
+	const source = "op/watcher/events"
+
+	q := createTestDeadLetterQueue(t, "op/watcher/dead-letters")
+	defer q.Close()
+	q.client.Del(source, q.Sink)
+	defer q.client.Del(source, q.Sink)
+
+	// the entries are dead-lettered by a Watcher
+	w := createTestWatcher(0, nil)
+	defer w.cancel()
+	w.DeadLetterSink = q.Sink
+	w.client = q.client
+	for _, id := range []string{"1-0", "2-0"} {
+		ev, message := createTestEvent()
+		message.ID = id
+		if err := w.deadLetter(ev, message, 3, errHandleEvent); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	letters, err := q.List("-", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(letters) != 2 {
+		t.Fatalf("expect %v dead letters, but got %v", 2, len(letters))
+	}
+	letter := letters[0]
+	if letter.Source != source ||
+		letter.SourceID != "1-0" ||
+		letter.Group != "group" ||
+		letter.Error != errHandleEvent.Error() ||
+		letter.Deliveries != 3 ||
+		letter.Timestamp == 0 {
+		t.Errorf("unexpected dead letter %+v", letter)
+	}
+	if ev := letter.Event(); ev.Action != ACTION_EXPIRED || ev.LeaseID != "lease-1" {
+		t.Errorf("unexpected event %+v", ev)
+	}
+
+	// Get
+	found, err := q.Get(letter.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found == nil || found.ID != letter.ID || found.SourceID != letter.SourceID {
+		t.Errorf("expect %+v, but got %+v", letter, found)
+	}
+	found, err = q.Get("0-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found != nil {
+		t.Errorf("expect nil, but got %+v", found)
+	}
+
+	// Requeue appends the original entry to the source sink
+	id, err := q.Requeue(letter.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(id) == 0 {
+		t.Fatalf("expect the ID of the requeued entry")
+	}
+	messages, err := q.client.XRange(source, "-", "+").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 1 || messages[0].ID != id {
+		t.Fatalf("expect the entry %v in %v, but got %v", id, source, messages)
+	}
+	for k := range messages[0].Values {
+		if strings.HasPrefix(k, "dlq_") {
+			t.Errorf("the requeued entry should not keep the field %v", k)
+		}
+	}
+	if ev := letter.Event(); messages[0].Values["lease"] != ev.LeaseID || messages[0].Values["action"] != string(ev.Action) {
+		t.Errorf("unexpected requeued entry %v", messages[0].Values)
+	}
+	found, err = q.Get(letter.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found != nil {
+		t.Errorf("the requeued entry should be removed from the dead-letter sink")
+	}
+	id, err = q.Requeue(letter.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(id) != 0 {
+		t.Errorf("expect an empty ID requeueing an absent entry, but got %v", id)
+	}
+
+	// Remove
+	count, err := q.Remove(letters[1].ID, "0-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("expect %v, but got %v", 1, count)
+	}
+	letters, err = q.List("-", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(letters) != 0 {
+		t.Errorf("expect no dead letters, but got %v", len(letters))
+	}
+}
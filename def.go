@@ -1,8 +1,7 @@
 package lease
 
 import (
-	"log"
-	"os"
+	"errors"
 	"time"
 
 	"github.com/bcowtech/lib-redis-lease/internal"
@@ -12,10 +11,35 @@ import (
 
 const (
 	LOGGER_PREFIX string = "[bcowtech/lib-redis-lease] "
+
+	DEFAULT_KEY_PREFIX         string = internal.DEFAULT_KEY_PREFIX
+	DEFAULT_LIST_LIMIT         int64  = internal.DEFAULT_LIST_LIMIT
+	DEFAULT_MIGRATE_BATCH_SIZE int64  = internal.DEFAULT_MIGRATE_BATCH_SIZE
+
+	DEFAULT_REAPER_HA_LEASE_ID  string        = "reaper"
+	DEFAULT_REAPER_HA_LEASE_TTL time.Duration = 10 * time.Second
+
+	DEFAULT_REAPER_PARTITION_MEMBER_TTL time.Duration = 10 * time.Second
+
+	DEFAULT_REAPER_RESTART_MIN_BACKOFF time.Duration = 1 * time.Second
+	DEFAULT_REAPER_RESTART_MAX_BACKOFF time.Duration = 1 * time.Minute
+
+	FAILURE_POLICY_STOP    FailurePolicy = "stop"
+	FAILURE_POLICY_RESTART FailurePolicy = "restart"
+	FAILURE_POLICY_SKIP    FailurePolicy = "skip"
+
+	LEASE_KEY_LAYOUT_VERSION int = internal.LEASE_KEY_LAYOUT_VERSION
+
+	// start offsets of a consumer group; any stream entry ID is valid too
+	GROUP_OFFSET_ZERO   string = "0"
+	GROUP_OFFSET_LATEST string = "$"
 )
 
 var (
-	logger *log.Logger = log.New(os.Stdout, LOGGER_PREFIX, log.LstdFlags|log.Lmsgprefix)
+	ErrInvalidCursor = internal.ErrInvalidCursor
+	ErrStaleRenewal  = internal.ErrStaleRenewal
+
+	ErrRevisionNotSupported = errors.New("the LeaseStore does not issue revisions")
 )
 
 // stuct & interface
@@ -24,9 +48,17 @@ type (
 	Timestamp = internal.Timestamp
 	LeaseArg  = internal.LeaseArg
 
+	LeaseStore         = internal.LeaseStore
+	LeaseBatchStore    = internal.LeaseBatchStore
+	LeaseRevisionStore = internal.LeaseRevisionStore
+	SinkTrimResult     = internal.SinkTrimResult
+	ListOptions        = internal.ListOptions
+	LeasePage          = internal.LeasePage
+
 	RedisClient  = redis.UniversalClient
 	RedisOption  = redis.UniversalOptions
 	StreamOffset = stream.StreamOffset
+	XMessage     = redis.XMessage
 
 	LeaseReaperHook interface {
 		OnProcess(sender *LeaseReaper, workspace, eventSink string, expireAt time.Time)
@@ -35,6 +67,58 @@ type (
 		OnStart(sender *LeaseReaper)
 		OnStop(sender *LeaseReaper)
 	}
+
+	// LeaseReaperTrimHook is an optional LeaseReaperHook called after the
+	// retention of a contract trims its event sink.
+	LeaseReaperTrimHook interface {
+		OnTrim(sender *LeaseReaper, workspace, eventSink string, trim SinkTrimResult)
+	}
+
+	// LeaseReaperCompletionHook is an optional LeaseReaperHook called after
+	// each sweep of a contract, with the number of expired leases.
+	LeaseReaperCompletionHook interface {
+		OnComplete(sender *LeaseReaper, workspace, eventSink string, count int64, elapsed time.Duration, err error)
+	}
+
+	// LeaseReaperFailureHook is an optional LeaseReaperHook called when a
+	// contract fails with an error not handled by the ErrorHandler, before
+	// the FailurePolicy is applied.
+	LeaseReaperFailureHook interface {
+		OnFailure(sender *LeaseReaper, workspace, eventSink string, policy FailurePolicy, err error)
+	}
+
+	// LeaseReaperActivityHook is an optional LeaseReaperHook called when the
+	// LeaseReaper in HA mode becomes the active sweeper or goes on standby.
+	LeaseReaperActivityHook interface {
+		OnActive(sender *LeaseReaper)
+		OnStandby(sender *LeaseReaper)
+	}
+
+	// LessorHook is called after each Grant, KeepAlive and Revoke. A zero
+	// expireAt without error means the lease was not renewed, and
+	// ErrStaleRenewal that the renewal was stale; the revision is zero
+	// unless the Store is a LeaseRevisionStore.
+	LessorHook interface {
+		OnGrant(sender *Lessor, workspace, leaseID string, ok bool, revision int64, err error)
+		OnKeepAlive(sender *Lessor, workspace, leaseID string, expireAt Timestamp, err error)
+		OnRevoke(sender *Lessor, workspace, leaseID string, ok bool, err error)
+	}
+
+	WatcherHook interface {
+		OnHandle(sender *Watcher, ev *Event, elapsed time.Duration, err error)
+	}
+
+	// WatcherDeadLetterHook is an optional WatcherHook called after an event
+	// is moved to the DeadLetterSink, with the last handler error.
+	WatcherDeadLetterHook interface {
+		OnDeadLetter(sender *Watcher, ev *Event, err error)
+	}
+
+	LeaseScriptHook = internal.LeaseScriptHook
+
+	// FailurePolicy decides what the LeaseReaper does when a contract fails
+	// with an error not handled by the ErrorHandler.
+	FailurePolicy string
 )
 
 // func
@@ -0,0 +1,324 @@
+package lease
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	ELECTION_METADATA_CANDIDATE = "candidate"
+)
+
+// Leadership describes the leader of an Election. An empty Leader means the
+// leadership is vacant.
+type Leadership struct {
+	Leader   string
+	Revision int64
+}
+
+// Election campaigns for the leadership lease named Name in the Workspace.
+// The leadership lease is a Mutex whose metadata carries the candidate, so
+// it is granted to one campaigner at a time and expired by the LeaseReaper
+// when the leader dies without resigning.
+//
+// To observe the changes of other campaigners, feed the events of the sink
+// bound to the Workspace to HandleEvent, e.g. from the EventHandler of a
+// Watcher. Each observing process needs its own consumer group, because a
+// consumer group delivers an event to one consumer only.
+type Election struct {
+	Lessor            *Lessor
+	Workspace         string
+	Name              string
+	TTL               time.Duration
+	KeepAliveInterval time.Duration
+	RetryInterval     time.Duration
+	ErrorHandler      func(err error)
+
+	term       *electionTerm
+	leadership Leadership
+	observers  map[chan Leadership]struct{}
+	sequence   int64
+	mutex      sync.Mutex
+}
+
+type electionTerm struct {
+	lock   *Mutex
+	done   chan struct{}
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// Campaign blocks until the candidate is elected or the ctx is done. Once
+// elected, the leadership lease is kept alive in the background until
+// Resign is called or the lease is lost. The lease is considered lost as
+// soon as it would have expired since the last successful renewal, even if
+// the renewals only fail transiently.
+func (e *Election) Campaign(ctx context.Context, candidate string) error {
+	e.validate()
+	if len(candidate) == 0 {
+		logger.Panic("specified argument 'candidate' cannot be an empty string")
+	}
+
+	e.mutex.Lock()
+	if e.term != nil {
+		e.mutex.Unlock()
+		return nil
+	}
+	e.mutex.Unlock()
+
+	lock := &Mutex{
+		Lessor:        e.Lessor,
+		Workspace:     e.Workspace,
+		Key:           e.Name,
+		TTL:           e.TTL,
+		RetryInterval: e.RetryInterval,
+		Metadata: map[string]string{
+			ELECTION_METADATA_CANDIDATE: candidate,
+		},
+	}
+	if err := lock.Lock(ctx); err != nil {
+		return err
+	}
+
+	termCtx, cancel := context.WithCancel(context.Background())
+	term := &electionTerm{
+		lock:   lock,
+		done:   make(chan struct{}),
+		cancel: cancel,
+	}
+
+	e.mutex.Lock()
+	e.term = term
+	e.publish(Leadership{Leader: candidate, Revision: lock.Revision()})
+	e.mutex.Unlock()
+
+	term.wg.Add(1)
+	go e.keepAlive(termCtx, term)
+	return nil
+}
+
+// Resign gives up the leadership. It is a no-op if the Election is not the
+// leader.
+func (e *Election) Resign(ctx context.Context) error {
+	e.mutex.Lock()
+	term := e.term
+	e.mutex.Unlock()
+	if term == nil {
+		return nil
+	}
+
+	term.cancel()
+	term.wg.Wait()
+
+	revision := term.lock.Revision()
+	err := term.lock.Unlock(ctx)
+	if err == ErrMutexNotHeld {
+		err = nil
+	}
+	e.endTerm(term, revision)
+	return err
+}
+
+// Leader returns the current leadership read from the lease store.
+func (e *Election) Leader(ctx context.Context) (Leadership, error) {
+	e.validate()
+
+	lease, err := e.Lessor.LeaseContext(ctx, e.Workspace, e.Name)
+	if err != nil || lease == nil {
+		return Leadership{}, err
+	}
+	return Leadership{
+		Leader:   lease.Metadata[ELECTION_METADATA_CANDIDATE],
+		Revision: lease.Revision,
+	}, nil
+}
+
+// IsLeader reports whether the Election holds the leadership.
+func (e *Election) IsLeader() bool {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	return e.term != nil
+}
+
+// Done returns a channel which is closed when the current term ends, either
+// by Resign or because the leadership lease is lost. It returns a closed
+// channel if the Election is not the leader.
+func (e *Election) Done() <-chan struct{} {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if e.term != nil {
+		return e.term.done
+	}
+	ch := make(chan struct{})
+	close(ch)
+	return ch
+}
+
+// Observe returns a channel of leadership changes. The channel first receives
+// the current leadership and keeps only the latest change if the receiver
+// falls behind. It is closed when the ctx is done.
+func (e *Election) Observe(ctx context.Context) <-chan Leadership {
+	e.validate()
+
+	ch := make(chan Leadership, 1)
+
+	e.mutex.Lock()
+	if e.observers == nil {
+		e.observers = make(map[chan Leadership]struct{})
+	}
+	e.observers[ch] = struct{}{}
+	sequence := e.sequence
+	e.mutex.Unlock()
+
+	go func() {
+		current, err := e.Leader(ctx)
+		if err == nil {
+			e.mutex.Lock()
+			if e.sequence == sequence {
+				e.leadership = current
+				sendLeadership(ch, current)
+			}
+			e.mutex.Unlock()
+		} else if e.ErrorHandler != nil {
+			e.ErrorHandler(err)
+		}
+
+		<-ctx.Done()
+
+		e.mutex.Lock()
+		delete(e.observers, ch)
+		close(ch)
+		e.mutex.Unlock()
+	}()
+	return ch
+}
+
+// HandleEvent applies a lifecycle event of the leadership lease to the
+// observers. Events of other leases are ignored, so HandleEvent can be used as
+// the EventHandler of a Watcher or called from one.
+func (e *Election) HandleEvent(ev *Event) error {
+	if ev.Workspace != e.Workspace || ev.LeaseID != e.Name {
+		return nil
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	// events of an older term arrive late, discard them
+	if ev.Revision > 0 && ev.Revision < e.leadership.Revision {
+		return nil
+	}
+
+	switch ev.Action {
+	case ACTION_GRANTED:
+		e.publish(Leadership{
+			Leader:   ev.Metadata[ELECTION_METADATA_CANDIDATE],
+			Revision: ev.Revision,
+		})
+	case ACTION_REVOKED, ACTION_EXPIRED:
+		e.publish(Leadership{Revision: ev.Revision})
+	}
+	return nil
+}
+
+func (e *Election) keepAlive(ctx context.Context, term *electionTerm) {
+	defer term.wg.Done()
+
+	var interval = e.KeepAliveInterval
+	if interval <= 0 {
+		interval = e.TTL / 3
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	// the term ends when the lease would have expired since the last
+	// successful renewal, since another campaigner may be elected by then
+	expireAt := term.lock.expiry()
+	expiry := time.NewTimer(time.Until(expireAt.ToTime()))
+	defer expiry.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-expiry.C:
+			e.endTerm(term, term.lock.Revision())
+			return
+		case <-ticker.C:
+			value, err := term.lock.KeepAlive(ctx)
+			if err == nil {
+				if value > expireAt {
+					expireAt = value
+					if !expiry.Stop() {
+						<-expiry.C
+					}
+					expiry.Reset(time.Until(expireAt.ToTime()))
+				}
+				continue
+			}
+			if err == ErrMutexNotHeld {
+				e.endTerm(term, term.lock.Revision())
+				return
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			if e.ErrorHandler != nil {
+				e.ErrorHandler(err)
+			}
+		}
+	}
+}
+
+func (e *Election) endTerm(term *electionTerm, revision int64) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if e.term != term {
+		return
+	}
+	e.term = nil
+	close(term.done)
+
+	if revision >= e.leadership.Revision {
+		e.publish(Leadership{Revision: revision})
+	}
+}
+
+// publish must be called with e.mutex held.
+func (e *Election) publish(v Leadership) {
+	e.leadership = v
+	e.sequence++
+	for ch := range e.observers {
+		sendLeadership(ch, v)
+	}
+}
+
+func (e *Election) validate() {
+	if e.Lessor == nil {
+		logger.Panic("'Lessor' cannot be nil")
+	}
+	if len(e.Workspace) == 0 {
+		logger.Panic("'Workspace' cannot be an empty string")
+	}
+	if len(e.Name) == 0 {
+		logger.Panic("'Name' cannot be an empty string")
+	}
+	if e.TTL <= 0 {
+		logger.Panic("'TTL' must be greater than 0")
+	}
+}
+
+// sendLeadership replaces the pending value of the single-slot channel ch
+// with v.
+func sendLeadership(ch chan Leadership, v Leadership) {
+	select {
+	case <-ch:
+	default:
+	}
+	ch <- v
+}
@@ -0,0 +1,179 @@
+package lease
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var errStoreUnavailable = errors.New("the store is unavailable")
+
+// faultyLeaseStore fails the grants and the renewals while failing is set.
+type faultyLeaseStore struct {
+	LeaseStore
+	failing int32
+}
+
+func (s *faultyLeaseStore) PutContext(ctx context.Context, workspace, lease string, ttl time.Duration, timestamp time.Time, options ...*LeaseArg) (bool, error) {
+	if atomic.LoadInt32(&s.failing) == 1 {
+		return false, errStoreUnavailable
+	}
+	return s.LeaseStore.PutContext(ctx, workspace, lease, ttl, timestamp, options...)
+}
+
+func (s *faultyLeaseStore) RenewContext(ctx context.Context, workspace, lease string, timestamp time.Time, options ...*LeaseArg) (Timestamp, error) {
+	if atomic.LoadInt32(&s.failing) == 1 {
+		return 0, errStoreUnavailable
+	}
+	return s.LeaseStore.RenewContext(ctx, workspace, lease, timestamp, options...)
+}
+
+func createTestLessor(t *testing.T, store LeaseStore) *Lessor {
+	l := &Lessor{
+		Store:  store,
+		Logger: NopLogger{},
+	}
+	if err := l.Init(); err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func TestElection_CampaignAndResign(t *testing.T) {
+	lessor := createTestLessor(t, NewMemoryLeaseStore())
+	ctx := context.Background()
+
+	e1 := &Election{Lessor: lessor, Workspace: "op/election", Name: "leader", TTL: time.Second}
+	e2 := &Election{Lessor: lessor, Workspace: "op/election", Name: "leader", TTL: time.Second, RetryInterval: 10 * time.Millisecond}
+
+	if err := e1.Campaign(ctx, "node-1"); err != nil {
+		t.Fatal(err)
+	}
+	if !e1.IsLeader() {
+		t.Errorf("node-1 should be the leader")
+	}
+
+	leadership, err := e2.Leader(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var expectedLeader = "node-1"
+	if leadership.Leader != expectedLeader {
+		t.Errorf("expect %v, but got %v", expectedLeader, leadership.Leader)
+	}
+
+	// the leadership is held by node-1
+	{
+		ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		err := e2.Campaign(ctx, "node-2")
+		cancel()
+		if err != context.DeadlineExceeded {
+			t.Errorf("expect %v, but got %v", context.DeadlineExceeded, err)
+		}
+	}
+
+	done := e1.Done()
+	if err := e1.Resign(ctx); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+	default:
+		t.Errorf("Done should be closed after Resign")
+	}
+	if e1.IsLeader() {
+		t.Errorf("node-1 should not be the leader")
+	}
+
+	if err := e2.Campaign(ctx, "node-2"); err != nil {
+		t.Fatal(err)
+	}
+	if !e2.IsLeader() {
+		t.Errorf("node-2 should be the leader")
+	}
+	e2.Resign(ctx)
+}
+
+func TestElection_KeepAlive(t *testing.T) {
+	lessor := createTestLessor(t, NewMemoryLeaseStore())
+	ctx := context.Background()
+
+	e := &Election{
+		Lessor:            lessor,
+		Workspace:         "op/election",
+		Name:              "leader",
+		TTL:               100 * time.Millisecond,
+		KeepAliveInterval: 20 * time.Millisecond,
+	}
+	if err := e.Campaign(ctx, "node-1"); err != nil {
+		t.Fatal(err)
+	}
+	defer e.Resign(ctx)
+
+	// outlives several TTLs
+	select {
+	case <-e.Done():
+		t.Fatalf("the term should not end")
+	case <-time.After(300 * time.Millisecond):
+	}
+	if !e.IsLeader() {
+		t.Errorf("node-1 should be the leader")
+	}
+}
+
+func TestElection_EndTermOnTransientErrors(t *testing.T) {
+	store := &faultyLeaseStore{LeaseStore: NewMemoryLeaseStore()}
+	lessor := createTestLessor(t, store)
+	ctx := context.Background()
+
+	var errs int32
+	e := &Election{
+		Lessor:            lessor,
+		Workspace:         "op/election",
+		Name:              "leader",
+		TTL:               100 * time.Millisecond,
+		KeepAliveInterval: 20 * time.Millisecond,
+		ErrorHandler: func(err error) {
+			atomic.AddInt32(&errs, 1)
+		},
+	}
+
+	var (
+		observeCtx, cancel = context.WithCancel(ctx)
+		observed           = e.Observe(observeCtx)
+	)
+	defer cancel()
+
+	if err := e.Campaign(ctx, "node-1"); err != nil {
+		t.Fatal(err)
+	}
+	atomic.StoreInt32(&store.failing, 1)
+
+	start := time.Now()
+	select {
+	case <-e.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("the term should end once the lease would have expired")
+	}
+	if elapsed := time.Since(start); elapsed > 250*time.Millisecond {
+		t.Errorf("the term should end within the TTL, but took %v", elapsed)
+	}
+	if e.IsLeader() {
+		t.Errorf("node-1 should not be the leader")
+	}
+	if atomic.LoadInt32(&errs) == 0 {
+		t.Errorf("ErrorHandler should be called")
+	}
+
+	var leadership Leadership
+	for leadership = range observed {
+		if len(leadership.Leader) == 0 {
+			break
+		}
+	}
+	if len(leadership.Leader) != 0 {
+		t.Errorf("the leadership should be vacant, but got %v", leadership.Leader)
+	}
+}
@@ -1,10 +1,42 @@
 package lease
 
+import "context"
+
+const (
+	ACTION_GRANTED EventAction = "GRANTED"
+	ACTION_RENEWED EventAction = "RENEWED"
+	ACTION_REVOKED EventAction = "REVOKED"
+	ACTION_EXPIRED EventAction = "EXPIRED"
+)
+
+type EventAction string
+
 type Event struct {
-	Action    string
+	Action    EventAction
 	Sink      string
 	Workspace string
 	LeaseID   string
 	ExpireAt  Timestamp
 	Timestamp Timestamp
+	Metadata  map[string]string
+	Revision  int64
+
+	// TraceParent is the W3C traceparent stored with the lease at grant
+	// time, if any.
+	TraceParent string
+
+	// Attempt is the number of times the Watcher has called the
+	// EventHandler with the event in the current delivery, starting at 1.
+	Attempt int
+
+	ctx context.Context
+}
+
+// Context returns the context of the Watcher which delivers the event. The
+// returned context is canceled when the Watcher is closed.
+func (ev *Event) Context() context.Context {
+	if ev.ctx != nil {
+		return ev.ctx
+	}
+	return context.Background()
 }
@@ -0,0 +1,49 @@
+package lease
+
+import (
+	"errors"
+	"sync"
+)
+
+var (
+	ErrStaleRevision = errors.New("the revision is older than the latest accepted revision")
+)
+
+// Fence rejects writes carrying a stale fencing token. A downstream service
+// keeps one Fence and validates the revision returned by Lessor.GrantWithRevision (or
+// Mutex.Revision) on each write to the guarded resource. Once a newer
+// revision is seen, writes from the previous holder are rejected even if the
+// holder has not noticed that its lease expired.
+type Fence struct {
+	revisions map[string]int64
+	mutex     sync.Mutex
+}
+
+// Validate accepts the revision and records it if it is not older than the
+// latest accepted revision of the resource. Otherwise it returns
+// ErrStaleRevision.
+func (f *Fence) Validate(resource string, revision int64) error {
+	if revision <= 0 {
+		logger.Panic("specified argument 'revision' must be greater than 0")
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.revisions == nil {
+		f.revisions = make(map[string]int64)
+	}
+	if revision < f.revisions[resource] {
+		return ErrStaleRevision
+	}
+	f.revisions[resource] = revision
+	return nil
+}
+
+// Revision returns the latest accepted revision of the resource.
+func (f *Fence) Revision(resource string) int64 {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return f.revisions[resource]
+}
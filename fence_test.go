@@ -0,0 +1,79 @@
+package lease
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFence_Validate(t *testing.T) {
+	var fence Fence
+
+	cases := []struct {
+		resource string
+		revision int64
+		err      error
+		expected int64
+	}{
+		{"op/a", 2, nil, 2},
+		// the same holder keeps writing
+		{"op/a", 2, nil, 2},
+		{"op/a", 5, nil, 5},
+		// the previous holder is rejected
+		{"op/a", 3, ErrStaleRevision, 5},
+		// the resources are fenced apart
+		{"op/b", 1, nil, 1},
+		{"op/a", 1, ErrStaleRevision, 5},
+	}
+	for i, c := range cases {
+		if err := fence.Validate(c.resource, c.revision); err != c.err {
+			t.Errorf("#%d: expect %v, but got %v", i, c.err, err)
+		}
+		if revision := fence.Revision(c.resource); revision != c.expected {
+			t.Errorf("#%d: expect revision %v, but got %v", i, c.expected, revision)
+		}
+	}
+}
+
+func TestFence_Revision(t *testing.T) {
+	var fence Fence
+
+	if revision := fence.Revision("op/a"); revision != 0 {
+		t.Errorf("expect %v, but got %v", 0, revision)
+	}
+}
+
+func TestFence_GrantWithRevision(t *testing.T) {
+	lessor := &Lessor{Store: NewMemoryLeaseStore()}
+	if err := lessor.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	var fence Fence
+
+	now := time.Now()
+	first, err := lessor.GrantWithRevision("op/fence", Lease{ID: "holder-1", TTL: time.Second}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fence.Validate("op/fence/resource", first); err != nil {
+		t.Fatal(err)
+	}
+
+	// the lease is taken over once revoked
+	if _, err := lessor.Revoke("op/fence", "holder-1"); err != nil {
+		t.Fatal(err)
+	}
+	second, err := lessor.GrantWithRevision("op/fence", Lease{ID: "holder-2", TTL: time.Second}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second <= first {
+		t.Fatalf("expect a revision greater than %v, but got %v", first, second)
+	}
+	if err := fence.Validate("op/fence/resource", second); err != nil {
+		t.Fatal(err)
+	}
+	if err := fence.Validate("op/fence/resource", first); err != ErrStaleRevision {
+		t.Errorf("expect %v, but got %v", ErrStaleRevision, err)
+	}
+}
@@ -0,0 +1,22 @@
+package helper
+
+import (
+	"fmt"
+
+	redis "github.com/go-redis/redis/v7"
+)
+
+func CreateRedisClusterClient(opt *redis.UniversalOptions) (redis.UniversalClient, error) {
+	client := redis.NewClusterClient(opt.Cluster())
+	if client == nil {
+		return nil, fmt.Errorf("fail to create redis.ClusterClient")
+	}
+
+	_, err := client.Ping().Result()
+	if err != nil {
+		if err != redis.Nil {
+			return nil, err
+		}
+	}
+	return client, nil
+}
@@ -0,0 +1,17 @@
+package helper
+
+import "strings"
+
+// HashTag returns the hash tag of the specified key according to the Redis
+// Cluster key distribution model, or an empty string if the key has none.
+func HashTag(key string) string {
+	start := strings.IndexByte(key, '{')
+	if start < 0 {
+		return ""
+	}
+	end := strings.IndexByte(key[start+1:], '}')
+	if end <= 0 {
+		return ""
+	}
+	return key[start+1 : start+1+end]
+}
@@ -0,0 +1,14 @@
+package helper
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
+
+func RandomToken() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
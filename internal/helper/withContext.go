@@ -0,0 +1,23 @@
+package helper
+
+import (
+	"context"
+
+	redis "github.com/go-redis/redis/v7"
+)
+
+func WithContext(client redis.UniversalClient, ctx context.Context) redis.UniversalClient {
+	if ctx == nil {
+		panic("specified argument 'ctx' cannot be nil")
+	}
+
+	switch c := client.(type) {
+	case *redis.Client:
+		return c.WithContext(ctx)
+	case *redis.ClusterClient:
+		return c.WithContext(ctx)
+	case *redis.Ring:
+		return c.WithContext(ctx)
+	}
+	return client
+}
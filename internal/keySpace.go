@@ -0,0 +1,72 @@
+package internal
+
+import (
+	"fmt"
+
+	"github.com/bcowtech/lib-redis-lease/internal/helper"
+)
+
+const (
+	DEFAULT_KEY_PREFIX = "lease:"
+
+	DEFAULT_MIGRATE_BATCH_SIZE int64 = 100
+
+	// LEASE_KEY_LAYOUT_VERSION is the version of the key layout which the
+	// lease scripts operate on. Layout 1 uses the lease ID itself as the hash
+	// key; layout 2 derives the hash key from the prefix, the workspace and
+	// the lease ID.
+	LEASE_KEY_LAYOUT_VERSION = 2
+)
+
+type KeySpace struct {
+	Prefix string
+}
+
+func NewKeySpace(prefix string) *KeySpace {
+	if len(prefix) == 0 {
+		prefix = DEFAULT_KEY_PREFIX
+	}
+	return &KeySpace{
+		Prefix: prefix,
+	}
+}
+
+func (k *KeySpace) LeaseKey(workspace, lease string) string {
+	return k.Prefix + workspace + ":" + lease
+}
+
+func (k *KeySpace) MetaKey(workspace string) string {
+	return k.Prefix + workspace + "#meta"
+}
+
+// ValidateClusterPrefix checks that the prefix leaves the hash tag of the
+// derived keys to the workspace.
+func (k *KeySpace) ValidateClusterPrefix() error {
+	if helper.HashTag(k.Prefix+":") != "" {
+		return fmt.Errorf("key prefix '%s' cannot contain a hash tag in cluster mode", k.Prefix)
+	}
+	return nil
+}
+
+// ValidateClusterKeys checks that the workspace, its lease hashes, its meta
+// key and the specified sinks hash to the same Redis Cluster slot.
+func (k *KeySpace) ValidateClusterKeys(workspace string, sinks ...string) error {
+	if err := k.ValidateClusterPrefix(); err != nil {
+		return err
+	}
+
+	tag := helper.HashTag(workspace)
+	if len(tag) == 0 {
+		return fmt.Errorf("workspace '%s' must contain a hash tag such as '{%s}' in cluster mode", workspace, workspace)
+	}
+	if helper.HashTag(k.LeaseKey(workspace, "")) != tag ||
+		helper.HashTag(k.MetaKey(workspace)) != tag {
+		return fmt.Errorf("lease keys of workspace '%s' cannot share hash tag '{%s}'", workspace, tag)
+	}
+	for _, sink := range sinks {
+		if helper.HashTag(sink) != tag {
+			return fmt.Errorf("event sink '%s' must share hash tag '{%s}' with workspace '%s' in cluster mode", sink, tag, workspace)
+		}
+	}
+	return nil
+}
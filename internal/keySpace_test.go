@@ -0,0 +1,26 @@
+package internal
+
+import "testing"
+
+func TestKeySpace_ValidateClusterKeys(t *testing.T) {
+	var cases = []struct {
+		prefix    string
+		workspace string
+		sink      string
+		valid     bool
+	}{
+		{"lease:", "{op}/lease", "{op}/lease/events", true},
+		{"lease:", "op/lease", "op/lease/events", false},
+		{"lease:", "{op}/lease", "{ev}/lease/events", false},
+		{"{lease}:", "{op}/lease", "{op}/lease/events", false},
+		{"lease{", "{op}/lease", "{op}/lease/events", false},
+	}
+
+	for _, c := range cases {
+		keyspace := NewKeySpace(c.prefix)
+		err := keyspace.ValidateClusterKeys(c.workspace, c.sink)
+		if (err == nil) != c.valid {
+			t.Errorf("ValidateClusterKeys(%q, %q) with prefix %q: expect valid %v, but got %v", c.workspace, c.sink, c.prefix, c.valid, err)
+		}
+	}
+}
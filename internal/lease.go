@@ -12,10 +12,12 @@ import (
 //_ go:generate msgp -tests=false
 
 type Lease struct {
-	ID        string        `json:"id"                   msg:"id"`
-	TTL       time.Duration `json:"ttl"                  msg:"ttl"`
-	Timestamp Timestamp     `json:"timestamp"            msg:"timestamp"`
-	ExpireAt  *Timestamp    `json:"expire_at,omitempty"  msg:"expire_at"`
+	ID        string            `json:"id"                   msg:"id"`
+	TTL       time.Duration     `json:"ttl"                  msg:"ttl"`
+	Timestamp Timestamp         `json:"timestamp"            msg:"timestamp"`
+	ExpireAt  *Timestamp        `json:"expire_at,omitempty"  msg:"expire_at"`
+	Metadata  map[string]string `json:"metadata,omitempty"   msg:"metadata"`
+	Revision  int64             `json:"revision,omitempty"   msg:"revision"`
 }
 
 func (l *Lease) TimeToLive() *time.Duration {
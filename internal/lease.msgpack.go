@@ -64,6 +64,51 @@ func (z *Lease) DecodeMsg(dc *msgp.Reader) (err error) {
 					return
 				}
 			}
+		case "metadata":
+			if dc.IsNil() {
+				err = dc.ReadNil()
+				if err != nil {
+					err = msgp.WrapError(err, "Metadata")
+					return
+				}
+				z.Metadata = nil
+			} else {
+				var zb0002 uint32
+				zb0002, err = dc.ReadMapHeader()
+				if err != nil {
+					err = msgp.WrapError(err, "Metadata")
+					return
+				}
+				if z.Metadata == nil {
+					z.Metadata = make(map[string]string, zb0002)
+				} else if len(z.Metadata) > 0 {
+					for key := range z.Metadata {
+						delete(z.Metadata, key)
+					}
+				}
+				for zb0002 > 0 {
+					zb0002--
+					var za0001 string
+					var za0002 string
+					za0001, err = dc.ReadString()
+					if err != nil {
+						err = msgp.WrapError(err, "Metadata")
+						return
+					}
+					za0002, err = dc.ReadString()
+					if err != nil {
+						err = msgp.WrapError(err, "Metadata", za0001)
+						return
+					}
+					z.Metadata[za0001] = za0002
+				}
+			}
+		case "revision":
+			z.Revision, err = dc.ReadInt64()
+			if err != nil {
+				err = msgp.WrapError(err, "Revision")
+				return
+			}
 		default:
 			err = dc.Skip()
 			if err != nil {
@@ -77,9 +122,9 @@ func (z *Lease) DecodeMsg(dc *msgp.Reader) (err error) {
 
 // EncodeMsg implements msgp.Encodable
 func (z *Lease) EncodeMsg(en *msgp.Writer) (err error) {
-	// map header, size 4
+	// map header, size 6
 	// write "id"
-	err = en.Append(0x84, 0xa2, 0x69, 0x64)
+	err = en.Append(0x86, 0xa2, 0x69, 0x64)
 	if err != nil {
 		return
 	}
@@ -125,15 +170,47 @@ func (z *Lease) EncodeMsg(en *msgp.Writer) (err error) {
 			return
 		}
 	}
+	// write "metadata"
+	err = en.Append(0xa8, 0x6d, 0x65, 0x74, 0x61, 0x64, 0x61, 0x74, 0x61)
+	if err != nil {
+		return
+	}
+	err = en.WriteMapHeader(uint32(len(z.Metadata)))
+	if err != nil {
+		err = msgp.WrapError(err, "Metadata")
+		return
+	}
+	for za0001, za0002 := range z.Metadata {
+		err = en.WriteString(za0001)
+		if err != nil {
+			err = msgp.WrapError(err, "Metadata")
+			return
+		}
+		err = en.WriteString(za0002)
+		if err != nil {
+			err = msgp.WrapError(err, "Metadata", za0001)
+			return
+		}
+	}
+	// write "revision"
+	err = en.Append(0xa8, 0x72, 0x65, 0x76, 0x69, 0x73, 0x69, 0x6f, 0x6e)
+	if err != nil {
+		return
+	}
+	err = en.WriteInt64(z.Revision)
+	if err != nil {
+		err = msgp.WrapError(err, "Revision")
+		return
+	}
 	return
 }
 
 // MarshalMsg implements msgp.Marshaler
 func (z *Lease) MarshalMsg(b []byte) (o []byte, err error) {
 	o = msgp.Require(b, z.Msgsize())
-	// map header, size 4
+	// map header, size 6
 	// string "id"
-	o = append(o, 0x84, 0xa2, 0x69, 0x64)
+	o = append(o, 0x86, 0xa2, 0x69, 0x64)
 	o = msgp.AppendString(o, z.ID)
 	// string "ttl"
 	o = append(o, 0xa3, 0x74, 0x74, 0x6c)
@@ -160,6 +237,16 @@ func (z *Lease) MarshalMsg(b []byte) (o []byte, err error) {
 			return
 		}
 	}
+	// string "metadata"
+	o = append(o, 0xa8, 0x6d, 0x65, 0x74, 0x61, 0x64, 0x61, 0x74, 0x61)
+	o = msgp.AppendMapHeader(o, uint32(len(z.Metadata)))
+	for za0001, za0002 := range z.Metadata {
+		o = msgp.AppendString(o, za0001)
+		o = msgp.AppendString(o, za0002)
+	}
+	// string "revision"
+	o = append(o, 0xa8, 0x72, 0x65, 0x76, 0x69, 0x73, 0x69, 0x6f, 0x6e)
+	o = msgp.AppendInt64(o, z.Revision)
 	return
 }
 
@@ -218,6 +305,50 @@ func (z *Lease) UnmarshalMsg(bts []byte) (o []byte, err error) {
 					return
 				}
 			}
+		case "metadata":
+			if msgp.IsNil(bts) {
+				bts, err = msgp.ReadNilBytes(bts)
+				if err != nil {
+					return
+				}
+				z.Metadata = nil
+			} else {
+				var zb0002 uint32
+				zb0002, bts, err = msgp.ReadMapHeaderBytes(bts)
+				if err != nil {
+					err = msgp.WrapError(err, "Metadata")
+					return
+				}
+				if z.Metadata == nil {
+					z.Metadata = make(map[string]string, zb0002)
+				} else if len(z.Metadata) > 0 {
+					for key := range z.Metadata {
+						delete(z.Metadata, key)
+					}
+				}
+				for zb0002 > 0 {
+					var za0001 string
+					var za0002 string
+					zb0002--
+					za0001, bts, err = msgp.ReadStringBytes(bts)
+					if err != nil {
+						err = msgp.WrapError(err, "Metadata")
+						return
+					}
+					za0002, bts, err = msgp.ReadStringBytes(bts)
+					if err != nil {
+						err = msgp.WrapError(err, "Metadata", za0001)
+						return
+					}
+					z.Metadata[za0001] = za0002
+				}
+			}
+		case "revision":
+			z.Revision, bts, err = msgp.ReadInt64Bytes(bts)
+			if err != nil {
+				err = msgp.WrapError(err, "Revision")
+				return
+			}
 		default:
 			bts, err = msgp.Skip(bts)
 			if err != nil {
@@ -238,5 +369,13 @@ func (z *Lease) Msgsize() (s int) {
 	} else {
 		s += z.ExpireAt.Msgsize()
 	}
+	s += 9 + msgp.MapHeaderSize
+	if z.Metadata != nil {
+		for za0001, za0002 := range z.Metadata {
+			_ = za0002
+			s += msgp.StringPrefixSize + len(za0001) + msgp.StringPrefixSize + len(za0002)
+		}
+	}
+	s += 9 + msgp.Int64Size
 	return
 }
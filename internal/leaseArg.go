@@ -1,11 +1,22 @@
 package internal
 
 import (
+	"encoding/json"
 	"math"
 	"reflect"
 	"strconv"
 )
 
+const (
+	LEASE_ARG_SINK        = "SINK"
+	LEASE_ARG_METADATA    = "METADATA"
+	LEASE_ARG_OWNER       = "OWNER"
+	LEASE_ARG_NX          = "NX"
+	LEASE_ARG_TRACEPARENT = "TRACEPARENT"
+	LEASE_ARG_MAXLEN      = "MAXLEN"
+	LEASE_ARG_MINID_AGE   = "MINID_AGE"
+)
+
 var _ Unpacker = new(LeaseArg)
 
 type LeaseArg struct {
@@ -46,3 +57,29 @@ func (arg *LeaseArg) Unpack() []interface{} {
 	}
 	return arr
 }
+
+func MetadataArg(metadata map[string]string) *LeaseArg {
+	var value interface{}
+	if len(metadata) > 0 {
+		buf, _ := json.Marshal(metadata)
+		value = string(buf)
+	}
+	return &LeaseArg{
+		Name:  LEASE_ARG_METADATA,
+		Value: value,
+	}
+}
+
+func lookupLeaseArg(options []*LeaseArg, name string) (string, bool) {
+	for _, opt := range options {
+		if opt == nil || opt.Name != name {
+			continue
+		}
+		if arr := opt.Unpack(); len(arr) > 1 {
+			if v, ok := arr[1].(string); ok {
+				return v, true
+			}
+		}
+	}
+	return "", false
+}
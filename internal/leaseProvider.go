@@ -2,15 +2,24 @@ package internal
 
 import (
 	"bytes"
+	"context"
+	"strconv"
 	"time"
 
+	"github.com/bcowtech/lib-redis-lease/internal/helper"
 	redis "github.com/go-redis/redis/v7"
 	"github.com/tinylib/msgp/msgp"
 )
 
 type LeaseProvider struct {
-	handle redis.UniversalClient
-	script *LeaseScript
+	KeyPrefix string
+
+	handle   redis.UniversalClient
+	script   *LeaseScript
+	keyspace *KeySpace
+	hooks    []LeaseScriptHook
+
+	clusterMode bool
 }
 
 func (p *LeaseProvider) Init(client redis.UniversalClient) {
@@ -20,53 +29,418 @@ func (p *LeaseProvider) Init(client redis.UniversalClient) {
 
 	p.handle = client
 	p.script = LeaseScriptInstance
+	p.keyspace = NewKeySpace(p.KeyPrefix)
+	_, p.clusterMode = client.(*redis.ClusterClient)
+}
+
+// AddHook adds a hook which is called after each script execution. It is not
+// safe to call AddHook concurrently with the other methods.
+func (p *LeaseProvider) AddHook(hook LeaseScriptHook) {
+	p.hooks = append(p.hooks, hook)
+}
+
+func (p *LeaseProvider) Put(workspace, lease string, ttl time.Duration, timestamp time.Time, options ...*LeaseArg) (ok bool, err error) {
+	return p.PutContext(context.Background(), workspace, lease, ttl, timestamp, options...)
+}
+
+func (p *LeaseProvider) PutContext(ctx context.Context, workspace, lease string, ttl time.Duration, timestamp time.Time, options ...*LeaseArg) (ok bool, err error) {
+	revision, err := p.PutWithRevisionContext(ctx, workspace, lease, ttl, timestamp, options...)
+	return revision > 0, err
+}
+
+func (p *LeaseProvider) PutWithRevision(workspace, lease string, ttl time.Duration, timestamp time.Time, options ...*LeaseArg) (revision int64, err error) {
+	return p.PutWithRevisionContext(context.Background(), workspace, lease, ttl, timestamp, options...)
 }
 
-func (p *LeaseProvider) Put(workspace, lease string, ttl time.Duration, timestamp time.Time) (ok bool, err error) {
+func (p *LeaseProvider) PutWithRevisionContext(ctx context.Context, workspace, lease string, ttl time.Duration, timestamp time.Time, options ...*LeaseArg) (revision int64, err error) {
 	var (
 		ttl_ms       int64 = ttl.Milliseconds()
 		timestamp_ms int64 = timestamp.UnixNano() / int64(time.Millisecond)
 	)
 
 	if ttl_ms > 0 {
-		reply, err := p.script.Exec(p.handle, LEASE_LUA_PUT, []string{workspace, lease}, ttl_ms, timestamp_ms)
+		keys, options := p.leaseKeys(workspace, lease, options)
+		reply, err := p.exec(ctx, LEASE_LUA_PUT, keys, redisArgs(lease, ttl_ms, timestamp_ms).NamedArguments(options...)...)
+		return parseRevisionReply(reply, err)
+	}
+	return 0, nil
+}
+
+func (p *LeaseProvider) Get(workspace, lease string) (*Lease, error) {
+	return p.GetContext(context.Background(), workspace, lease)
+}
+
+func (p *LeaseProvider) GetContext(ctx context.Context, workspace, lease string) (*Lease, error) {
+	reply, err := p.exec(ctx, LEASE_LUA_GET, []string{workspace, p.keyspace.LeaseKey(workspace, lease)}, lease)
+	return parseLeaseReply(lease, reply, err)
+}
+
+func (p *LeaseProvider) Delete(workspace, lease string, options ...*LeaseArg) (ok bool, err error) {
+	return p.DeleteContext(context.Background(), workspace, lease, options...)
+}
+
+func (p *LeaseProvider) DeleteContext(ctx context.Context, workspace, lease string, options ...*LeaseArg) (ok bool, err error) {
+	keys, options := p.leaseKeys(workspace, lease, options)
+	reply, err := p.exec(ctx, LEASE_LUA_DELETE, keys, redisArgs(lease).NamedArguments(options...)...)
+	return parseStatusReply(reply, err)
+}
+
+func (p *LeaseProvider) Renew(workspace, lease string, timestamp time.Time, options ...*LeaseArg) (Timestamp, error) {
+	return p.RenewContext(context.Background(), workspace, lease, timestamp, options...)
+}
+
+func (p *LeaseProvider) RenewContext(ctx context.Context, workspace, lease string, timestamp time.Time, options ...*LeaseArg) (Timestamp, error) {
+	var (
+		timestamp_ms int64 = timestamp.UnixNano() / int64(time.Millisecond)
+	)
+
+	keys, options := p.leaseKeys(workspace, lease, options)
+	reply, err := p.exec(ctx, LEASE_LUA_RENEW, keys, redisArgs(lease, timestamp_ms).NamedArguments(options...)...)
+	return parseRenewReply(reply, err)
+}
+
+func (p *LeaseProvider) Expire(workspace, sink string, timestamp time.Time, options ...*LeaseArg) (count int64, err error) {
+	return p.ExpireContext(context.Background(), workspace, sink, timestamp, options...)
+}
+
+func (p *LeaseProvider) ExpireContext(ctx context.Context, workspace, sink string, timestamp time.Time, options ...*LeaseArg) (count int64, err error) {
+	count, _, err = p.ExpireAndTrimContext(ctx, workspace, sink, timestamp, options...)
+	return count, err
+}
+
+func (p *LeaseProvider) ExpireAndTrimContext(ctx context.Context, workspace, sink string, timestamp time.Time, options ...*LeaseArg) (count int64, trim SinkTrimResult, err error) {
+	var (
+		timestamp_ms int64 = timestamp.UnixNano() / int64(time.Millisecond)
+	)
+
+	var (
+		keys = []string{workspace, sink, p.keyspace.MetaKey(workspace)}
+		args = redisArgs(timestamp_ms).NamedArguments(options...).NamedArguments(p.prefixArg())
+	)
+	if !p.clusterMode {
+		// legacy hashes live outside the hash tag of the workspace, and
+		// cluster mode never had them
+		args = args.NamedArguments(&LeaseArg{Name: "LEGACY", Value: LEASE_KEY_LAYOUT_VERSION})
+	}
+
+	reply, err := p.exec(ctx, LEASE_LUA_EXPIRE, keys, args...)
+	if err != nil {
+		if err != redis.Nil {
+			return 0, trim, err
+		}
+	}
+
+	switch v := reply.(type) {
+	case int64:
+		return v, trim, nil
+	case []interface{}:
+		// { count, trimmed, { undelivered groups... }, { pending groups... } }
+		if len(v) > 0 {
+			count, _ = v[0].(int64)
+		}
+		if len(v) > 1 {
+			trim.Trimmed, _ = v[1].(int64)
+		}
+		if len(v) > 2 {
+			trim.UndeliveredGroups = stringsOf(v[2])
+		}
+		if len(v) > 3 {
+			trim.PendingGroups = stringsOf(v[3])
+		}
+		return count, trim, nil
+	}
+	return 0, trim, nil
+}
+
+func (p *LeaseProvider) Migrate(workspace string, batchSize int64) (count int64, err error) {
+	return p.MigrateContext(context.Background(), workspace, batchSize)
+}
+
+// MigrateContext moves the lease hashes of the specified workspace from the
+// legacy key layout to the current one, scanning about batchSize leases at a
+// time. Once the scan completes the workspace is marked with
+// LEASE_KEY_LAYOUT_VERSION and later calls return immediately.
+func (p *LeaseProvider) MigrateContext(ctx context.Context, workspace string, batchSize int64) (count int64, err error) {
+	if batchSize <= 0 {
+		batchSize = DEFAULT_MIGRATE_BATCH_SIZE
+	}
+
+	var (
+		keys   = []string{workspace, p.keyspace.MetaKey(workspace)}
+		cursor = "0"
+	)
+	for {
+		reply, err := p.exec(ctx, LEASE_LUA_MIGRATE, keys, p.keyspace.Prefix, LEASE_KEY_LAYOUT_VERSION, cursor, batchSize)
 		if err != nil {
 			if err != redis.Nil {
-				return false, err
+				return count, err
 			}
 		}
 
-		if v, ok := reply.(string); ok {
-			return (v == "OK"), nil
+		values, ok := reply.([]interface{})
+		if !ok || len(values) < 2 {
+			return count, nil
 		}
+		if v, ok := values[1].(int64); ok {
+			count += v
+		}
+		if v, ok := values[0].(string); ok && v != "0" {
+			cursor = v
+			continue
+		}
+		return count, nil
 	}
-	return false, nil
 }
 
-func (p *LeaseProvider) Get(workspace, lease string) (*Lease, error) {
-	reply, err := p.script.Exec(p.handle, LEASE_LUA_GET, []string{workspace, lease})
+func (p *LeaseProvider) ListAlive(workspace string, timestamp time.Time) ([]string, error) {
+	return p.ListAliveContext(context.Background(), workspace, timestamp)
+}
+
+// ListAliveContext returns the IDs of the leases in the specified workspace
+// which expire after the timestamp.
+func (p *LeaseProvider) ListAliveContext(ctx context.Context, workspace string, timestamp time.Time) ([]string, error) {
+	var (
+		timestamp_ms int64 = timestamp.UnixNano() / int64(time.Millisecond)
+	)
+
+	return helper.WithContext(p.handle, ctx).ZRangeByScore(workspace, &redis.ZRangeBy{
+		Min: "(" + strconv.FormatInt(timestamp_ms, 10),
+		Max: "+inf",
+	}).Result()
+}
+
+func (p *LeaseProvider) List(workspace string, opts ListOptions) (*LeasePage, error) {
+	return p.ListContext(context.Background(), workspace, opts)
+}
+
+// ListContext pages through the leases of the workspace by rank with ZRANGE
+// in a script, which filters the prefix and reads the lease hashes if
+// details are requested.
+func (p *LeaseProvider) ListContext(ctx context.Context, workspace string, opts ListOptions) (*LeasePage, error) {
+	cursor, err := parseListCursor(opts.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Limit <= 0 {
+		opts.Limit = DEFAULT_LIST_LIMIT
+	}
+
+	options := []*LeaseArg{
+		{Name: "LIMIT", Value: opts.Limit},
+		{Name: "SCAN", Value: opts.Limit * LIST_SCAN_FACTOR},
+		p.prefixArg(),
+	}
+	if opts.MinExpireAt > 0 {
+		options = append(options, &LeaseArg{Name: "MIN", Value: int64(opts.MinExpireAt)})
+	}
+	if opts.MaxExpireAt > 0 {
+		options = append(options, &LeaseArg{Name: "MAX", Value: int64(opts.MaxExpireAt)})
+	}
+	if len(opts.Prefix) > 0 {
+		options = append(options, &LeaseArg{Name: "MATCH", Value: opts.Prefix})
+	}
+	if opts.WithDetails {
+		options = append(options, &LeaseArg{Name: "DETAILS", Value: 1})
+	}
+	if cursor != nil {
+		options = append(options,
+			&LeaseArg{Name: "AFTER_SCORE", Value: cursor.expireAt},
+			&LeaseArg{Name: "AFTER_ID", Value: cursor.lease})
+	}
+
+	reply, err := p.exec(ctx, LEASE_LUA_LIST, []string{workspace}, redisArgs().NamedArguments(options...)...)
 	if err != nil {
 		if err != redis.Nil {
 			return nil, err
 		}
 	}
+	return parseListReply(reply)
+}
 
-	if reply != nil {
-		result := &Lease{
-			ID: lease,
+// PutManyContext puts the specified leases in a single pipeline. The TTL,
+// the ID and the metadata are taken from each lease; the options are applied
+// to all of them.
+func (p *LeaseProvider) PutManyContext(ctx context.Context, workspace string, leases []*Lease, timestamp time.Time, options ...*LeaseArg) ([]bool, []error, error) {
+	revisions, errs, err := p.PutManyWithRevisionContext(ctx, workspace, leases, timestamp, options...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return revisionsToOK(revisions), errs, nil
+}
+
+func (p *LeaseProvider) PutManyWithRevisionContext(ctx context.Context, workspace string, leases []*Lease, timestamp time.Time, options ...*LeaseArg) ([]int64, []error, error) {
+	var (
+		timestamp_ms int64 = timestamp.UnixNano() / int64(time.Millisecond)
+
+		results = make([]int64, len(leases))
+		errs    = make([]error, len(leases))
+		calls   = make([]*LeaseScriptCall, 0, len(leases))
+		indexes = make([]int, 0, len(leases))
+	)
+
+	for i, lease := range leases {
+		var ttl_ms int64 = lease.TTL.Milliseconds()
+		if ttl_ms <= 0 {
+			continue
 		}
-		reader := bytes.NewBuffer([]byte(reply.(string)))
-		err := msgp.Decode(reader, result)
-		if err != nil {
-			return nil, err
+
+		keys, args := p.leaseKeys(workspace, lease.ID, append(options[:len(options):len(options)], MetadataArg(lease.Metadata)))
+		calls = append(calls, &LeaseScriptCall{
+			Keys: keys,
+			Args: redisArgs(lease.ID, ttl_ms, timestamp_ms).NamedArguments(args...),
+		})
+		indexes = append(indexes, i)
+	}
+
+	cmds, err := p.execMany(ctx, LEASE_LUA_PUT, calls)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i, cmd := range cmds {
+		results[indexes[i]], errs[indexes[i]] = parseRevisionReply(cmd.Result())
+	}
+	return results, errs, nil
+}
+
+func (p *LeaseProvider) GetManyContext(ctx context.Context, workspace string, leases []string) ([]*Lease, []error, error) {
+	var (
+		results = make([]*Lease, len(leases))
+		errs    = make([]error, len(leases))
+		calls   = make([]*LeaseScriptCall, len(leases))
+	)
+
+	for i, lease := range leases {
+		calls[i] = &LeaseScriptCall{
+			Keys: []string{workspace, p.keyspace.LeaseKey(workspace, lease)},
+			Args: redisArgs(lease),
 		}
-		return result, nil
 	}
-	return nil, nil
+
+	cmds, err := p.execMany(ctx, LEASE_LUA_GET, calls)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i, cmd := range cmds {
+		reply, err := cmd.Result()
+		results[i], errs[i] = parseLeaseReply(leases[i], reply, err)
+	}
+	return results, errs, nil
 }
 
-func (p *LeaseProvider) Delete(workspace, lease string) (ok bool, err error) {
-	reply, err := p.script.Exec(p.handle, LEASE_LUA_DELETE, []string{workspace, lease})
+func (p *LeaseProvider) DeleteManyContext(ctx context.Context, workspace string, leases []string, options ...*LeaseArg) ([]bool, []error, error) {
+	var (
+		results = make([]bool, len(leases))
+		errs    = make([]error, len(leases))
+		calls   = make([]*LeaseScriptCall, len(leases))
+	)
+
+	for i, lease := range leases {
+		keys, args := p.leaseKeys(workspace, lease, options)
+		calls[i] = &LeaseScriptCall{
+			Keys: keys,
+			Args: redisArgs(lease).NamedArguments(args...),
+		}
+	}
+
+	cmds, err := p.execMany(ctx, LEASE_LUA_DELETE, calls)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i, cmd := range cmds {
+		results[i], errs[i] = parseStatusReply(cmd.Result())
+	}
+	return results, errs, nil
+}
+
+func (p *LeaseProvider) RenewManyContext(ctx context.Context, workspace string, leases []string, timestamp time.Time, options ...*LeaseArg) ([]Timestamp, []error, error) {
+	var (
+		timestamp_ms int64 = timestamp.UnixNano() / int64(time.Millisecond)
+
+		results = make([]Timestamp, len(leases))
+		errs    = make([]error, len(leases))
+		calls   = make([]*LeaseScriptCall, len(leases))
+	)
+
+	for i, lease := range leases {
+		keys, args := p.leaseKeys(workspace, lease, options)
+		calls[i] = &LeaseScriptCall{
+			Keys: keys,
+			Args: redisArgs(lease, timestamp_ms).NamedArguments(args...),
+		}
+	}
+
+	cmds, err := p.execMany(ctx, LEASE_LUA_RENEW, calls)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i, cmd := range cmds {
+		results[i], errs[i] = parseRenewReply(cmd.Result())
+	}
+	return results, errs, nil
+}
+
+// exec runs the script, timing it for the hooks if any.
+func (p *LeaseProvider) exec(ctx context.Context, name string, keys []string, args ...interface{}) (interface{}, error) {
+	if len(p.hooks) == 0 {
+		return p.script.ExecContext(ctx, p.handle, name, keys, args...)
+	}
+
+	start := time.Now()
+	reply, err := p.script.ExecContext(ctx, p.handle, name, keys, args...)
+	p.triggerOnExec(name, time.Since(start), err)
+	return reply, err
+}
+
+func (p *LeaseProvider) execMany(ctx context.Context, name string, calls []*LeaseScriptCall) ([]*redis.Cmd, error) {
+	if len(p.hooks) == 0 {
+		return p.script.ExecManyContext(ctx, p.handle, name, calls)
+	}
+
+	start := time.Now()
+	cmds, err := p.script.ExecManyContext(ctx, p.handle, name, calls)
+	p.triggerOnExec(name, time.Since(start), err)
+	return cmds, err
+}
+
+func (p *LeaseProvider) triggerOnExec(name string, elapsed time.Duration, err error) {
+	if err == redis.Nil {
+		err = nil
+	}
+	for _, h := range p.hooks {
+		h.OnExec(name, elapsed, err)
+	}
+}
+
+// leaseKeys returns the KEYS of the lease scripts, and moves the SINK
+// argument, if any, from the options to the KEYS.
+func (p *LeaseProvider) leaseKeys(workspace, lease string, options []*LeaseArg) ([]string, []*LeaseArg) {
+	var (
+		keys = []string{workspace, p.keyspace.LeaseKey(workspace, lease), p.keyspace.MetaKey(workspace)}
+		args = make([]*LeaseArg, 0, len(options))
+	)
+	for _, opt := range options {
+		if opt == nil {
+			continue
+		}
+		if opt.Name == LEASE_ARG_SINK {
+			if sink, ok := opt.Value.(string); ok && len(sink) > 0 {
+				keys = append(keys, sink)
+			}
+			continue
+		}
+		args = append(args, opt)
+	}
+	return keys, args
+}
+
+func (p *LeaseProvider) prefixArg() *LeaseArg {
+	return &LeaseArg{
+		Name:  "PREFIX",
+		Value: p.keyspace.Prefix,
+	}
+}
+
+func parseStatusReply(reply interface{}, err error) (ok bool, _ error) {
 	if err != nil {
 		if err != redis.Nil {
 			return false, err
@@ -79,12 +453,7 @@ func (p *LeaseProvider) Delete(workspace, lease string) (ok bool, err error) {
 	return false, nil
 }
 
-func (p *LeaseProvider) Renew(workspace, lease string, timestamp time.Time) (Timestamp, error) {
-	var (
-		timestamp_ms int64 = timestamp.UnixNano() / int64(time.Millisecond)
-	)
-
-	reply, err := p.script.Exec(p.handle, LEASE_LUA_RENEW, []string{workspace, lease}, timestamp_ms)
+func parseRevisionReply(reply interface{}, err error) (int64, error) {
 	if err != nil {
 		if err != redis.Nil {
 			return 0, err
@@ -92,17 +461,12 @@ func (p *LeaseProvider) Renew(workspace, lease string, timestamp time.Time) (Tim
 	}
 
 	if v, ok := reply.(int64); ok {
-		return Timestamp(v), nil
+		return v, nil
 	}
 	return 0, nil
 }
 
-func (p *LeaseProvider) Expire(workspace, sink string, timestamp time.Time, options ...*LeaseArg) (count int64, err error) {
-	var (
-		timestamp_ms int64 = timestamp.UnixNano() / int64(time.Millisecond)
-	)
-
-	reply, err := p.script.Exec(p.handle, LEASE_LUA_EXPIRE, []string{workspace, sink}, redisArgs(timestamp_ms).NamedArguments(options...)...)
+func parseTimestampReply(reply interface{}, err error) (Timestamp, error) {
 	if err != nil {
 		if err != redis.Nil {
 			return 0, err
@@ -110,7 +474,85 @@ func (p *LeaseProvider) Expire(workspace, sink string, timestamp time.Time, opti
 	}
 
 	if v, ok := reply.(int64); ok {
-		return v, nil
+		return Timestamp(v), nil
 	}
 	return 0, nil
 }
+
+// parseRenewReply parses the expiry of the renew script, or { expire_at,
+// "STALE" } for a stale renewal.
+func parseRenewReply(reply interface{}, err error) (Timestamp, error) {
+	if v, ok := reply.([]interface{}); ok && len(v) > 0 {
+		expireAt, _ := v[0].(int64)
+		return Timestamp(expireAt), ErrStaleRenewal
+	}
+	return parseTimestampReply(reply, err)
+}
+
+// parseListReply parses { cursor expire_at, cursor lease, [lease, expire_at,
+// details]... } of the list script.
+func parseListReply(reply interface{}) (*LeasePage, error) {
+	values, ok := reply.([]interface{})
+	if !ok || len(values) < 2 {
+		return &LeasePage{}, nil
+	}
+
+	page := &LeasePage{
+		Leases: make([]*Lease, 0, (len(values)-2)/3),
+	}
+	if score, ok := values[0].(string); ok && len(score) > 0 {
+		expireAt, err := strconv.ParseInt(score, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		id, _ := values[1].(string)
+		page.Cursor = listCursor{expireAt: expireAt, lease: id}.String()
+	}
+
+	for i := 2; i+2 < len(values); i += 3 {
+		id, _ := values[i].(string)
+
+		var lease *Lease
+		if details, ok := values[i+2].(string); ok && len(details) > 0 {
+			v, err := parseLeaseReply(id, details, nil)
+			if err != nil {
+				return nil, err
+			}
+			lease = v
+		} else {
+			lease = &Lease{ID: id}
+		}
+
+		if score, ok := values[i+1].(string); ok && lease.ExpireAt == nil {
+			expireAt, err := strconv.ParseInt(score, 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			ts := Timestamp(expireAt)
+			lease.ExpireAt = &ts
+		}
+		page.Leases = append(page.Leases, lease)
+	}
+	return page, nil
+}
+
+func parseLeaseReply(lease string, reply interface{}, err error) (*Lease, error) {
+	if err != nil {
+		if err != redis.Nil {
+			return nil, err
+		}
+	}
+
+	if v, ok := reply.(string); ok {
+		result := &Lease{
+			ID: lease,
+		}
+		reader := bytes.NewBuffer([]byte(v))
+		err := msgp.Decode(reader, result)
+		if err != nil {
+			return nil, err
+		}
+		return result, nil
+	}
+	return nil, nil
+}
@@ -1,6 +1,8 @@
 package internal
 
 import (
+	"context"
+	"fmt"
 	"os"
 	"testing"
 	"time"
@@ -20,29 +22,84 @@ func TestLeaseProvider_Put(t *testing.T) {
 	p := new(LeaseProvider)
 	p.Init(client)
 	{
-		ok, err := p.Put("op/lease", "lease-1", 300*time.Millisecond, time.Date(2021, 9, 8, 16, 3, 4, 0, time.UTC))
+		revision, err := p.PutWithRevision("op/lease", "lease-1", 300*time.Millisecond, time.Date(2021, 9, 8, 16, 3, 4, 0, time.UTC))
 		if err != nil {
 			t.Fatal(err)
 		}
-		var expectedOK bool = true
-		if ok != expectedOK {
-			t.Errorf("expect %v, but got %v", expectedOK, ok)
+		var expectedRevision int64 = 1
+		if revision != expectedRevision {
+			t.Errorf("expect %v, but got %v", expectedRevision, revision)
 		}
 	}
 
 	// duplicated operation
 	{
-		ok, err := p.Put("op/lease", "lease-1", 300*time.Millisecond, time.Date(2021, 9, 8, 16, 3, 4, 0, time.UTC))
+		revision, err := p.PutWithRevision("op/lease", "lease-1", 300*time.Millisecond, time.Date(2021, 9, 8, 16, 3, 4, 0, time.UTC))
 		if err != nil {
 			t.Fatal(err)
 		}
-		var expectedOK bool = false
-		if ok != expectedOK {
-			t.Errorf("expect %v, but got %v", expectedOK, ok)
+		var expectedRevision int64 = 0
+		if revision != expectedRevision {
+			t.Errorf("expect %v, but got %v", expectedRevision, revision)
+		}
+	}
+
+	// later operation
+	{
+		revision, err := p.PutWithRevision("op/lease", "lease-1", 300*time.Millisecond, time.Date(2021, 9, 8, 16, 3, 5, 0, time.UTC))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var expectedRevision int64 = 2
+		if revision != expectedRevision {
+			t.Errorf("expect %v, but got %v", expectedRevision, revision)
+		}
+	}
+
+	client.Del("op/lease", "lease:op/lease#meta", "lease:op/lease:lease-1")
+}
+
+func TestLeaseProvider_Put_WithSink(t *testing.T) {
+	client, err := helper.CreateRedisUniversalClient(&redis.UniversalOptions{
+		Addrs: []string{os.Getenv("REDIS_SERVER")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := new(LeaseProvider)
+	p.Init(client)
+
+	var optArgs = []*LeaseArg{
+		{
+			Name:  LEASE_ARG_SINK,
+			Value: "op/lease/events",
+		},
+	}
+	_, err = p.Put("op/lease", "lease-1", 300*time.Millisecond, time.Date(2021, 9, 8, 16, 3, 4, 0, time.UTC), optArgs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.Delete("op/lease", "lease-1", optArgs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messages, err := client.XRange("op/lease/events", "-", "+").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var expectedActions = []string{"GRANTED", "REVOKED"}
+	if len(messages) != len(expectedActions) {
+		t.Fatalf("expect %v events, but got %v", len(expectedActions), len(messages))
+	}
+	for i, expectedAction := range expectedActions {
+		if messages[i].Values["action"] != expectedAction {
+			t.Errorf("event #%d: expect %v, but got %v", i, expectedAction, messages[i].Values["action"])
 		}
 	}
 
-	client.Del("op/lease", "lease-1")
+	client.Del("op/lease/events", "op/lease", "lease:op/lease#meta", "lease:op/lease:lease-1")
 }
 
 func TestLeaseProvider_Get(t *testing.T) {
@@ -92,7 +149,48 @@ func TestLeaseProvider_Get(t *testing.T) {
 		}
 	}
 
-	client.Del("op/lease", "lease-1")
+	client.Del("op/lease", "lease:op/lease#meta", "lease:op/lease:lease-1")
+}
+
+func TestLeaseProvider_Get_WithMetadata(t *testing.T) {
+	client, err := helper.CreateRedisUniversalClient(&redis.UniversalOptions{
+		Addrs: []string{os.Getenv("REDIS_SERVER")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := new(LeaseProvider)
+	p.Init(client)
+
+	var optArgs = []*LeaseArg{
+		{
+			Name:  "METADATA",
+			Value: `{"owner":"user-42"}`,
+		},
+	}
+	_, err = p.Put("op/lease", "lease-1", 300*time.Millisecond, time.Date(2021, 9, 8, 16, 3, 4, 0, time.UTC), optArgs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lease, err := p.Get("op/lease", "lease-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if lease == nil {
+		t.Errorf("Lease should not be nil")
+	}
+
+	if lease != nil {
+		var expectedOwner string = "user-42"
+		if lease.Metadata["owner"] != expectedOwner {
+			t.Errorf("Lease.Metadata[owner]: expect %v, but got %v", expectedOwner, lease.Metadata["owner"])
+		}
+	}
+
+	client.Del("op/lease", "lease:op/lease#meta", "lease:op/lease:lease-1")
 }
 
 func TestLeaseProvider_Delete(t *testing.T) {
@@ -135,7 +233,7 @@ func TestLeaseProvider_Delete(t *testing.T) {
 		}
 	}
 
-	client.Del("op/lease", "lease-1")
+	client.Del("op/lease", "lease:op/lease#meta", "lease:op/lease:lease-1")
 }
 
 func TestLeaseProvider_Renew(t *testing.T) {
@@ -165,9 +263,10 @@ func TestLeaseProvider_Renew(t *testing.T) {
 		}
 	}
 	{
+		// stale renewal
 		expireAt, err := p.Renew("op/lease", "lease-1", time.Date(2021, 9, 8, 16, 3, 4, int(150*time.Millisecond), time.UTC))
-		if err != nil {
-			t.Fatal(err)
+		if err != ErrStaleRenewal {
+			t.Errorf("expect %v, but got %v", ErrStaleRenewal, err)
 		}
 
 		var expectedExpireAt Timestamp = 1631116984450
@@ -176,7 +275,7 @@ func TestLeaseProvider_Renew(t *testing.T) {
 		}
 	}
 
-	client.Del("op/lease", "lease-1")
+	client.Del("op/lease", "lease:op/lease#meta", "lease:op/lease:lease-1")
 }
 
 func TestLeaseProvider_Expire(t *testing.T) {
@@ -205,7 +304,7 @@ func TestLeaseProvider_Expire(t *testing.T) {
 		t.Errorf("expect %v, but got %v", expectedExpired, expired)
 	}
 
-	client.Del("op/lease/events", "op/lease", "lease-1")
+	client.Del("op/lease/events", "op/lease", "lease:op/lease#meta", "lease:op/lease:lease-1")
 }
 
 func TestLeaseProvider_Expire_WithLimit(t *testing.T) {
@@ -249,8 +348,407 @@ func TestLeaseProvider_Expire_WithLimit(t *testing.T) {
 		t.Errorf("expect %v, but got %v", expectedExpired, expired)
 	}
 
-	client.Del("op/lease/events", "op/lease",
-		"lease-1",
-		"lease-2",
-		"lease-3")
+	client.Del("op/lease/events", "op/lease", "lease:op/lease#meta",
+		"lease:op/lease:lease-1",
+		"lease:op/lease:lease-2",
+		"lease:op/lease:lease-3")
+}
+
+func TestLeaseProvider_PutMany(t *testing.T) {
+	client, err := helper.CreateRedisUniversalClient(&redis.UniversalOptions{
+		Addrs: []string{os.Getenv("REDIS_SERVER")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := new(LeaseProvider)
+	p.Init(client)
+
+	var leases = []*Lease{
+		{ID: "lease-1", TTL: 300 * time.Millisecond},
+		{ID: "lease-2", TTL: 300 * time.Millisecond},
+	}
+	{
+		revisions, errs, err := p.PutManyWithRevisionContext(context.Background(), "op/lease", leases, time.Date(2021, 9, 8, 16, 3, 4, 0, time.UTC))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var expectedRevisions = []int64{1, 2}
+		for i := range leases {
+			if errs[i] != nil {
+				t.Fatal(errs[i])
+			}
+			if revisions[i] != expectedRevisions[i] {
+				t.Errorf("lease #%d: expect %v, but got %v", i, expectedRevisions[i], revisions[i])
+			}
+		}
+	}
+
+	{
+		expireAts, errs, err := p.RenewManyContext(context.Background(), "op/lease", []string{"lease-1", "lease-3"}, time.Date(2021, 9, 8, 16, 3, 4, int(150*time.Millisecond), time.UTC))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var expectedExpireAts = []Timestamp{1631116984450, 0}
+		for i := range expectedExpireAts {
+			if errs[i] != nil {
+				t.Fatal(errs[i])
+			}
+			if expireAts[i] != expectedExpireAts[i] {
+				t.Errorf("lease #%d: expect %v, but got %v", i, expectedExpireAts[i], expireAts[i])
+			}
+		}
+	}
+
+	client.Del("op/lease", "lease:op/lease#meta",
+		"lease:op/lease:lease-1",
+		"lease:op/lease:lease-2")
+}
+
+func TestLeaseProvider_Migrate(t *testing.T) {
+	client, err := helper.CreateRedisUniversalClient(&redis.UniversalOptions{
+		Addrs: []string{os.Getenv("REDIS_SERVER")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// legacy layout
+	client.ZAdd("op/lease", &redis.Z{
+		Score:  1631116984300,
+		Member: "lease-1",
+	})
+	client.HSet("lease-1", "ttl", 300, "timestamp", 1631116984000)
+
+	p := new(LeaseProvider)
+	p.Init(client)
+	{
+		migrated, err := p.Migrate("op/lease", 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var expectedMigrated int64 = 1
+		if migrated != expectedMigrated {
+			t.Errorf("expect %v, but got %v", expectedMigrated, migrated)
+		}
+	}
+
+	lease, err := p.Get("op/lease", "lease-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lease == nil {
+		t.Errorf("Lease should not be nil")
+	}
+	if lease != nil {
+		var expectedTTL time.Duration = 300 * time.Millisecond
+		if lease.TTL != expectedTTL {
+			t.Errorf("Lease.TTL: expect %v, but got %v", expectedTTL, lease.TTL)
+		}
+	}
+
+	// duplicated operation
+	{
+		migrated, err := p.Migrate("op/lease", 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var expectedMigrated int64 = 0
+		if migrated != expectedMigrated {
+			t.Errorf("expect %v, but got %v", expectedMigrated, migrated)
+		}
+	}
+
+	client.Del("op/lease", "lease-1", "lease:op/lease:lease-1", "lease:op/lease#meta")
+}
+
+func TestLeaseProvider_Migrate_ManyBatches(t *testing.T) {
+	client, err := helper.CreateRedisUniversalClient(&redis.UniversalOptions{
+		Addrs: []string{os.Getenv("REDIS_SERVER")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// legacy layout
+	const total = 250
+	keys := []string{"op/lease", "lease:op/lease#meta"}
+	for i := 0; i < total; i++ {
+		id := fmt.Sprintf("lease-%d", i)
+		client.ZAdd("op/lease", &redis.Z{
+			Score:  1631116984300,
+			Member: id,
+		})
+		client.HSet(id, "ttl", 300, "timestamp", 1631116984000)
+		keys = append(keys, id, "lease:op/lease:"+id)
+	}
+	defer client.Del(keys...)
+
+	p := new(LeaseProvider)
+	p.Init(client)
+
+	migrated, err := p.Migrate("op/lease", 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var expectedMigrated int64 = total
+	if migrated != expectedMigrated {
+		t.Errorf("expect %v, but got %v", expectedMigrated, migrated)
+	}
+	for i := 0; i < total; i++ {
+		id := fmt.Sprintf("lease-%d", i)
+		if n := client.Exists(id).Val(); n != 0 {
+			t.Errorf("legacy hash %s should be moved", id)
+		}
+	}
+}
+
+func TestLeaseProvider_Expire_LegacyLayout(t *testing.T) {
+	client, err := helper.CreateRedisUniversalClient(&redis.UniversalOptions{
+		Addrs: []string{os.Getenv("REDIS_SERVER")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// legacy layout
+	client.ZAdd("op/lease", &redis.Z{
+		Score:  1631116984300,
+		Member: "lease-1",
+	})
+	client.HSet("lease-1", "ttl", 300, "timestamp", 1631116984000)
+	defer client.Del("op/lease/events", "op/lease", "lease-1", "lease:op/lease#meta")
+
+	p := new(LeaseProvider)
+	p.Init(client)
+
+	expired, err := p.Expire("op/lease", "op/lease/events",
+		time.Date(2021, 9, 8, 16, 3, 4, int(301*time.Millisecond), time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var expectedExpired int64 = 1
+	if expired != expectedExpired {
+		t.Errorf("expect %v, but got %v", expectedExpired, expired)
+	}
+	if n := client.Exists("lease-1").Val(); n != 0 {
+		t.Errorf("legacy hash should be deleted")
+	}
+}
+
+func TestLeaseProvider_Owner(t *testing.T) {
+	client, err := helper.CreateRedisUniversalClient(&redis.UniversalOptions{
+		Addrs: []string{os.Getenv("REDIS_SERVER")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Del("op/lock", "lease:op/lock#meta", "lease:op/lock:lock-1")
+
+	var (
+		owner = &LeaseArg{Name: LEASE_ARG_OWNER, Value: "token-1"}
+		other = &LeaseArg{Name: LEASE_ARG_OWNER, Value: "token-2"}
+	)
+
+	p := new(LeaseProvider)
+	p.Init(client)
+	_, err = p.Put("op/lock", "lock-1", 300*time.Millisecond, time.Date(2021, 9, 8, 16, 3, 4, 0, time.UTC), owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, options := range [][]*LeaseArg{nil, {other}} {
+		expireAt, err := p.Renew("op/lock", "lock-1", time.Date(2021, 9, 8, 16, 3, 5, 0, time.UTC), options...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expireAt != 0 {
+			t.Errorf("expect %v, but got %v", 0, expireAt)
+		}
+
+		ok, err := p.Delete("op/lock", "lock-1", options...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok {
+			t.Errorf("expect %v, but got %v", false, ok)
+		}
+	}
+
+	ok, err := p.Delete("op/lock", "lock-1", owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Errorf("expect %v, but got %v", true, ok)
+	}
+}
+
+func TestLeaseProvider_ExpireAndTrim_Groups(t *testing.T) {
+	client, err := helper.CreateRedisUniversalClient(&redis.UniversalOptions{
+		Addrs: []string{os.Getenv("REDIS_SERVER")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Del("op/lease/events")
+
+	// enough entries for the approximate trimming to remove a whole node
+	for i := 1; i <= 250; i++ {
+		client.XAdd(&redis.XAddArgs{
+			Stream: "op/lease/events",
+			ID:     fmt.Sprintf("%d-0", i),
+			Values: map[string]interface{}{"action": "EXPIRED"},
+		})
+	}
+	client.XGroupCreate("op/lease/events", "lagging", "0")
+	client.XGroupCreate("op/lease/events", "pending", "0")
+	client.XGroupCreate("op/lease/events", "caught-up", "$")
+	client.XReadGroup(&redis.XReadGroupArgs{
+		Group:    "pending",
+		Consumer: "consumer-1",
+		Streams:  []string{"op/lease/events", ">"},
+		Count:    300,
+	})
+
+	p := new(LeaseProvider)
+	p.Init(client)
+
+	_, trim, err := p.ExpireAndTrimContext(context.Background(), "op/lease", "op/lease/events",
+		time.Date(2021, 9, 8, 16, 3, 4, 0, time.UTC),
+		&LeaseArg{Name: LEASE_ARG_MAXLEN, Value: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trim.Trimmed == 0 {
+		t.Fatalf("expect the sink to be trimmed")
+	}
+	if len(trim.UndeliveredGroups) != 1 || trim.UndeliveredGroups[0] != "lagging" {
+		t.Errorf("expect %v, but got %v", []string{"lagging"}, trim.UndeliveredGroups)
+	}
+	if len(trim.PendingGroups) != 1 || trim.PendingGroups[0] != "pending" {
+		t.Errorf("expect %v, but got %v", []string{"pending"}, trim.PendingGroups)
+	}
+
+	// the pending entries trimmed already are not reported again
+	for _, message := range client.XRange("op/lease/events", "-", "+").Val() {
+		client.XAck("op/lease/events", "pending", message.ID)
+	}
+	for i := 251; i <= 500; i++ {
+		client.XAdd(&redis.XAddArgs{
+			Stream: "op/lease/events",
+			ID:     fmt.Sprintf("%d-0", i),
+			Values: map[string]interface{}{"action": "EXPIRED"},
+		})
+	}
+	_, trim, err = p.ExpireAndTrimContext(context.Background(), "op/lease", "op/lease/events",
+		time.Date(2021, 9, 8, 16, 3, 4, 0, time.UTC),
+		&LeaseArg{Name: LEASE_ARG_MAXLEN, Value: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trim.PendingGroups) != 0 {
+		t.Errorf("expect no pending groups, but got %v", trim.PendingGroups)
+	}
+}
+
+func TestLeaseProvider_List_SameScore(t *testing.T) {
+	client, err := helper.CreateRedisUniversalClient(&redis.UniversalOptions{
+		Addrs: []string{os.Getenv("REDIS_SERVER")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// many more leases of the same score than a List call scans
+	const total = 250
+	var (
+		leases = make([]*Lease, total)
+		keys   = []string{"op/lease", "lease:op/lease#meta"}
+	)
+	for i := range leases {
+		id := fmt.Sprintf("lease-%03d", i)
+		leases[i] = &Lease{ID: id, TTL: 300 * time.Millisecond}
+		keys = append(keys, "lease:op/lease:"+id)
+	}
+	defer client.Del(keys...)
+
+	p := new(LeaseProvider)
+	p.Init(client)
+	_, _, err = p.PutManyContext(context.Background(), "op/lease", leases, time.Date(2021, 9, 8, 16, 3, 4, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, prefix := range []string{"", "lease-1"} {
+		var (
+			ids  []string
+			opts = ListOptions{
+				Prefix: prefix,
+				Limit:  3,
+			}
+		)
+		for pages := 0; ; pages++ {
+			if pages > total {
+				t.Fatalf("%q: the listing does not progress", prefix)
+			}
+			page, err := p.List("op/lease", opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, lease := range page.Leases {
+				ids = append(ids, lease.ID)
+			}
+			if len(page.Cursor) == 0 {
+				break
+			}
+			opts.Cursor = page.Cursor
+
+			// the lease of the cursor is gone on the next call
+			if pages == 1 {
+				cursor, err := parseListCursor(page.Cursor)
+				if err != nil {
+					t.Fatal(err)
+				}
+				client.ZRem("op/lease", cursor.lease)
+			}
+		}
+
+		var expectedIDs []string
+		for _, lease := range leases {
+			if len(prefix) == 0 || lease.ID[:len(prefix)] == prefix {
+				expectedIDs = append(expectedIDs, lease.ID)
+			}
+		}
+		if len(ids) != len(expectedIDs) {
+			t.Fatalf("%q: expect %v leases, but got %v", prefix, len(expectedIDs), len(ids))
+		}
+		for i := range ids {
+			if ids[i] != expectedIDs[i] {
+				t.Errorf("%q: expect %v at %v, but got %v", prefix, expectedIDs[i], i, ids[i])
+				break
+			}
+		}
+	}
+}
+
+func TestLeaseProvider_List_LastPage(t *testing.T) {
+	client, err := helper.CreateRedisUniversalClient(&redis.UniversalOptions{
+		Addrs: []string{os.Getenv("REDIS_SERVER")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Del("op/list", "lease:op/list#meta",
+		"lease:op/list:lease-1",
+		"lease:op/list:lease-2",
+		"lease:op/list:lease-3",
+		"lease:op/list:lease-4",
+		"lease:op/list:lock-1")
+
+	p := new(LeaseProvider)
+	p.Init(client)
+
+	testListLastPage(t, p, "op/list")
 }
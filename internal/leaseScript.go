@@ -1,22 +1,55 @@
 package internal
 
 import (
+	"context"
 	"fmt"
+	"strings"
+	"sync"
 
+	"github.com/bcowtech/lib-redis-lease/internal/helper"
 	redis "github.com/go-redis/redis/v7"
 )
 
 const (
 	LEASE_LUA_PUT  = "put"
 	LUA_SCRIPT_PUT = `
-if #KEYS < 2 then
+if #KEYS < 3 then
 	return redis.error_reply("ILLEGAL_ARGUMENTS")
 end
 
 local WORKSPACE = KEYS[1]
-local LEASE_ID  = KEYS[2]
-local TTL       = tonumber(ARGV[1])
-local TIMESTAMP = tonumber(ARGV[2])
+local LEASE_KEY = KEYS[2]
+local META_KEY  = KEYS[3]
+local SINK      = KEYS[4]
+local LEASE_ID  = ARGV[1]
+local TTL       = tonumber(ARGV[2])
+local TIMESTAMP = tonumber(ARGV[3])
+
+local METADATA, OWNER, NX, TRACEPARENT
+
+if ARGV then
+	if (#ARGV - 3) % 2 ~= 0 then
+		return redis.error_reply("ILLEGAL_ARGUMENTS")
+	end
+
+	local ARGV_SETTER = {
+		METADATA    = function(v) METADATA    = v           end,
+		OWNER       = function(v) OWNER       = v           end,
+		NX          = function(v) NX          = (v == "1")  end,
+		TRACEPARENT = function(v) TRACEPARENT = v           end,
+	}
+
+	for i = 4, #ARGV, 2 do
+		local k = ARGV[i]
+		local setter = ARGV_SETTER[k]
+		if setter then
+			local err = setter(ARGV[i+1])
+			if err then
+				return err
+			end
+		end
+	end
+end
 
 if TIMESTAMP and TTL and LEASE_ID and WORKSPACE then
 	if WORKSPACE == "" then  return redis.error_reply("INVALID_ARGUMENT")  end
@@ -25,8 +58,19 @@ if TIMESTAMP and TTL and LEASE_ID and WORKSPACE then
 	local LAST_UPDATE_AT
 	local EXPIRE_AT = TIMESTAMP + TTL
 
+	if NX then
+		local reply = redis.call('ZSCORE', WORKSPACE, LEASE_ID)
+		if type(reply)=='table' and reply.err then
+			return reply
+		end
+		-- an expired lease which is not reaped yet is treated as absent
+		if reply and tonumber(reply) > TIMESTAMP then
+			return redis.status_reply("NOP")
+		end
+	end
+
 	do
-		local reply = redis.call('HGET', LEASE_ID, "timestamp")
+		local reply = redis.call('HGET', LEASE_KEY, "timestamp")
 		if type(reply)=='table' and reply.err then
 			return reply
 		end
@@ -34,6 +78,16 @@ if TIMESTAMP and TTL and LEASE_ID and WORKSPACE then
 	end
 
 	if not LAST_UPDATE_AT  or  TIMESTAMP > LAST_UPDATE_AT then
+		local REVISION
+
+		do
+			local reply = redis.call('HINCRBY', META_KEY, "revision", 1)
+			if type(reply)=='table' and reply.err then
+				return reply
+			end
+			REVISION = reply
+		end
+
 		do
 			local reply = redis.call('ZADD', WORKSPACE, EXPIRE_AT, LEASE_ID)
 			if type(reply)=='table' and reply.err then
@@ -42,15 +96,75 @@ if TIMESTAMP and TTL and LEASE_ID and WORKSPACE then
 		end
 
 		do
-			local reply = redis.call('HSET' , LEASE_ID
+			local reply = redis.call('HSET' , LEASE_KEY
 																			, "ttl"      , TTL
-																			, "timestamp", TIMESTAMP)
+																			, "timestamp", TIMESTAMP
+																			, "revision" , REVISION)
+			if type(reply)=='table' and reply.err then
+				return reply
+			end
+		end
+
+		if METADATA and METADATA ~= "" then
+			local reply = redis.call('HSET', LEASE_KEY, "metadata", METADATA)
+			if type(reply)=='table' and reply.err then
+				return reply
+			end
+		else
+			local reply = redis.call('HDEL', LEASE_KEY, "metadata")
+			if type(reply)=='table' and reply.err then
+				return reply
+			end
+		end
+
+		if OWNER and OWNER ~= "" then
+			local reply = redis.call('HSET', LEASE_KEY, "owner", OWNER)
+			if type(reply)=='table' and reply.err then
+				return reply
+			end
+		else
+			local reply = redis.call('HDEL', LEASE_KEY, "owner")
+			if type(reply)=='table' and reply.err then
+				return reply
+			end
+		end
+
+		if TRACEPARENT and TRACEPARENT ~= "" then
+			local reply = redis.call('HSET', LEASE_KEY, "traceparent", TRACEPARENT)
+			if type(reply)=='table' and reply.err then
+				return reply
+			end
+		else
+			local reply = redis.call('HDEL', LEASE_KEY, "traceparent")
+			if type(reply)=='table' and reply.err then
+				return reply
+			end
+		end
+
+		if SINK and SINK ~= "" then
+			local fields = {
+				"action"   , 'GRANTED',
+				"workspace", WORKSPACE,
+				"lease"    , LEASE_ID,
+				"expire_at", EXPIRE_AT,
+				"revision" , REVISION,
+			}
+			if METADATA and METADATA ~= "" then
+				table.insert(fields, "metadata")
+				table.insert(fields, METADATA)
+			end
+			if TRACEPARENT and TRACEPARENT ~= "" then
+				table.insert(fields, "traceparent")
+				table.insert(fields, TRACEPARENT)
+			end
+
+			local reply = redis.call('XADD', SINK, '*', unpack(fields))
 			if type(reply)=='table' and reply.err then
 				return reply
 			end
 		end
 
-		return redis.status_reply("OK")
+		return REVISION
 	end
 end
 return redis.status_reply("NOP")`
@@ -62,23 +176,26 @@ if #KEYS < 2 then
 end
 
 local WORKSPACE = KEYS[1]
-local LEASE_ID  = KEYS[2]
+local LEASE_KEY = KEYS[2]
+local LEASE_ID  = ARGV[1]
 
 local RESULT
 if LEASE_ID and WORKSPACE then
 	if WORKSPACE == "" then  return redis.error_reply("INVALID_ARGUMENT")  end
 	if LEASE_ID  == "" then  return redis.error_reply("INVALID_ARGUMENT")  end
 
-	local TTL, TIMESTAMP, EXPIRE_AT
+	local TTL, TIMESTAMP, EXPIRE_AT, METADATA, REVISION
 
 	do
-		local reply = redis.call('HMGET', LEASE_ID
+		local reply = redis.call('HMGET', LEASE_KEY
 																		, "ttl"
-																		, "timestamp")
+																		, "timestamp"
+																		, "metadata"
+																		, "revision")
 		if type(reply)=='table' and reply.err then
 			return reply
 		end
-		TTL, TIMESTAMP = unpack(reply)
+		TTL, TIMESTAMP, METADATA, REVISION = unpack(reply)
 	end
 
 	do
@@ -94,8 +211,16 @@ if LEASE_ID and WORKSPACE then
 			ttl       = tonumber(TTL),
 			timestamp = tonumber(TIMESTAMP),
 			expire_at = tonumber(EXPIRE_AT),
+			revision  = tonumber(REVISION),
 		}
 
+		if METADATA then
+			local ok, metadata = pcall(cjson.decode, METADATA)
+			if ok and type(metadata)=='table' and next(metadata) then
+				result.metadata = metadata
+			end
+		end
+
 		if next(result) then
 			RESULT = cmsgpack.pack(result)
 		end
@@ -105,19 +230,76 @@ return RESULT`
 
 	LEASE_LUA_DELETE  = "delete"
 	LUA_SCRIPT_DELETE = `
-if #KEYS < 2 then
+if #KEYS < 3 then
 	return redis.error_reply("ILLEGAL_ARGUMENTS")
 end
 
 local WORKSPACE = KEYS[1]
-local LEASE_ID  = KEYS[2]
+local LEASE_KEY = KEYS[2]
+local META_KEY  = KEYS[3]
+local SINK      = KEYS[4]
+local LEASE_ID  = ARGV[1]
+
+local OWNER
+
+if ARGV then
+	if (#ARGV - 1) % 2 ~= 0 then
+		return redis.error_reply("ILLEGAL_ARGUMENTS")
+	end
+
+	local ARGV_SETTER = {
+		OWNER = function(v) if v ~= "" then OWNER = v end end,
+	}
+
+	for i = 2, #ARGV, 2 do
+		local k = ARGV[i]
+		local setter = ARGV_SETTER[k]
+		if setter then
+			local err = setter(ARGV[i+1])
+			if err then
+				return err
+			end
+		end
+	end
+end
 
 if LEASE_ID and WORKSPACE then
 	if WORKSPACE == "" then  return redis.error_reply("INVALID_ARGUMENT")  end
 	if LEASE_ID  == "" then  return redis.error_reply("INVALID_ARGUMENT")  end
 
+	-- a lease granted with an owner is only revoked by that owner
+	do
+		local reply = redis.call('HGET', LEASE_KEY, "owner")
+		if type(reply)=='table' and reply.err then
+			return reply
+		end
+		local LEASE_OWNER = reply or nil
+		if (OWNER or LEASE_OWNER) and LEASE_OWNER ~= OWNER then
+			return redis.status_reply("NOP")
+		end
+	end
+
+	local EXPIRE_AT, METADATA, REVISION, TRACEPARENT
+
+	if SINK and SINK ~= "" then
+		local reply = redis.call('HMGET', LEASE_KEY
+																		, "metadata"
+																		, "revision"
+																		, "traceparent")
+		if type(reply)=='table' and reply.err then
+			return reply
+		end
+		METADATA, REVISION, TRACEPARENT = unpack(reply)
+
+		reply = redis.call('ZSCORE', WORKSPACE, LEASE_ID)
+		if type(reply)=='table' and reply.err then
+			return reply
+		end
+		EXPIRE_AT = reply
+	end
+
 	do
-		local reply = redis.call('DEL', LEASE_ID)
+		local reply = redis.call('DEL', LEASE_KEY)
 		if type(reply)=='table' and reply.err then
 			return reply
 		end
@@ -130,6 +312,34 @@ if LEASE_ID and WORKSPACE then
 		end
 
 		if tonumber(reply)  and  reply > 0 then
+			if SINK and SINK ~= "" then
+				local fields = {
+					"action"   , 'REVOKED',
+					"workspace", WORKSPACE,
+					"lease"    , LEASE_ID,
+				}
+				if EXPIRE_AT then
+					table.insert(fields, "expire_at")
+					table.insert(fields, EXPIRE_AT)
+				end
+				if REVISION then
+					table.insert(fields, "revision")
+					table.insert(fields, REVISION)
+				end
+				if METADATA then
+					table.insert(fields, "metadata")
+					table.insert(fields, METADATA)
+				end
+				if TRACEPARENT then
+					table.insert(fields, "traceparent")
+					table.insert(fields, TRACEPARENT)
+				end
+
+				local reply = redis.call('XADD', SINK, '*', unpack(fields))
+				if type(reply)=='table' and reply.err then
+					return reply
+				end
+			end
 			return redis.status_reply("OK")
 		end
 	end
@@ -138,38 +348,74 @@ return redis.status_reply("NOP")`
 
 	LEASE_LUA_RENEW  = "renew"
 	LUA_SCRIPT_RENEW = `
-if #KEYS < 1 then
+if #KEYS < 3 then
 	return redis.error_reply("ILLEGAL_ARGUMENTS")
 end
 
 local WORKSPACE = KEYS[1]
-local LEASE_ID  = KEYS[2]
-local TIMESTAMP = tonumber(ARGV[1])
+local LEASE_KEY = KEYS[2]
+local META_KEY  = KEYS[3]
+local SINK      = KEYS[4]
+local LEASE_ID  = ARGV[1]
+local TIMESTAMP = tonumber(ARGV[2])
+
+local OWNER
+
+if ARGV then
+	if (#ARGV - 2) % 2 ~= 0 then
+		return redis.error_reply("ILLEGAL_ARGUMENTS")
+	end
+
+	local ARGV_SETTER = {
+		OWNER = function(v) if v ~= "" then OWNER = v end end,
+	}
+
+	for i = 3, #ARGV, 2 do
+		local k = ARGV[i]
+		local setter = ARGV_SETTER[k]
+		if setter then
+			local err = setter(ARGV[i+1])
+			if err then
+				return err
+			end
+		end
+	end
+end
 
 local RESULT
 if TIMESTAMP and LEASE_ID and WORKSPACE then
 	if WORKSPACE == "" then  return redis.error_reply("INVALID_ARGUMENT")  end
 	if LEASE_ID  == "" then  return redis.error_reply("INVALID_ARGUMENT")  end
 
-	local TTL, LAST_UPDATE_AT
+	local TTL, LAST_UPDATE_AT, METADATA, LEASE_OWNER, REVISION, TRACEPARENT
 
 	do
-		local reply = redis.call('HMGET', LEASE_ID
+		local reply = redis.call('HMGET', LEASE_KEY
 																		, "ttl"
-																		, "timestamp")
+																		, "timestamp"
+																		, "metadata"
+																		, "owner"
+																		, "revision"
+																		, "traceparent")
 		if type(reply)=='table' and reply.err then
 		return reply
 		end
-		TTL, LAST_UPDATE_AT = unpack(reply)
+		TTL, LAST_UPDATE_AT, METADATA, LEASE_OWNER, REVISION, TRACEPARENT = unpack(reply)
 
 		LAST_UPDATE_AT = tonumber(LAST_UPDATE_AT)
+		LEASE_OWNER    = LEASE_OWNER or nil
+	end
+
+	-- a lease granted with an owner is only renewed by that owner
+	if (OWNER or LEASE_OWNER) and LEASE_OWNER ~= OWNER then
+		return RESULT
 	end
 
 	if TTL  and (not LAST_UPDATE_AT  or  TIMESTAMP > LAST_UPDATE_AT) then
 		local expire_at = TIMESTAMP + TTL
 
 		do
-			local reply  = redis.call('HSET', LEASE_ID
+			local reply  = redis.call('HSET', LEASE_KEY
 																			, "timestamp", TIMESTAMP)
 			if type(reply)=='table' and reply.err then
 				return reply
@@ -185,13 +431,40 @@ if TIMESTAMP and LEASE_ID and WORKSPACE then
 				RESULT =  expire_at
 			end
 		end
+
+		if SINK and SINK ~= "" then
+			local fields = {
+				"action"   , 'RENEWED',
+				"workspace", WORKSPACE,
+				"lease"    , LEASE_ID,
+				"expire_at", expire_at,
+			}
+			if REVISION then
+				table.insert(fields, "revision")
+				table.insert(fields, REVISION)
+			end
+			if METADATA then
+				table.insert(fields, "metadata")
+				table.insert(fields, METADATA)
+			end
+			if TRACEPARENT then
+				table.insert(fields, "traceparent")
+				table.insert(fields, TRACEPARENT)
+			end
+
+			local reply = redis.call('XADD', SINK, '*', unpack(fields))
+			if type(reply)=='table' and reply.err then
+				return reply
+			end
+		end
 	else
+		-- a stale renewal returns the current expiry, marked as such
 		local reply = redis.call('ZSCORE', WORKSPACE, LEASE_ID)
 		if type(reply)=='table' and reply.err then
 			return reply
 		end
 		if reply then
-			RESULT = tonumber(reply)
+			RESULT = { tonumber(reply), "STALE" }
 		end
 	end
 end
@@ -205,9 +478,10 @@ end
 
 local WORKSPACE = KEYS[1]
 local SINK      = KEYS[2]
+local META_KEY  = KEYS[3]
 local TIMESTAMP = tonumber(ARGV[1])
 
-local LIMIT
+local LIMIT, PREFIX, MAXLEN, MINID_AGE, LEGACY
 
 if ARGV then
 	if (#ARGV - 1) % 2 ~= 0 then
@@ -215,7 +489,11 @@ if ARGV then
 	end
 
 	local ARGV_SETTER = {
-		LIMIT = function(v) LIMIT  = tonumber(v) end,
+		LIMIT     = function(v) LIMIT     = tonumber(v) end,
+		PREFIX    = function(v) PREFIX    = v           end,
+		MAXLEN    = function(v) MAXLEN    = tonumber(v) end,
+		MINID_AGE = function(v) MINID_AGE = tonumber(v) end,
+		LEGACY    = function(v) LEGACY    = tonumber(v) end,
 	}
 
 	for i = 2, #ARGV, 2 do
@@ -230,6 +508,15 @@ if ARGV then
 	end
 end
 
+local function stream_id_less(a, b)
+	local a_ms, a_seq = string.match(a, "^(%d+)-(%d+)$")
+	local b_ms, b_seq = string.match(b, "^(%d+)-(%d+)$")
+	if tonumber(a_ms) ~= tonumber(b_ms) then
+		return tonumber(a_ms) < tonumber(b_ms)
+	end
+	return tonumber(a_seq) < tonumber(b_seq)
+end
+
 local RESULT
 if TIMESTAMP and SINK and WORKSPACE then
 	if SINK    == "" then  return redis.error_reply("INVALID_ARGUMENT")  end
@@ -238,6 +525,21 @@ if TIMESTAMP and SINK and WORKSPACE then
 	if not LIMIT  or  LIMIT == 0 then
 		LIMIT = math.huge
 	end
+	if not PREFIX then
+		PREFIX = ""
+	end
+
+	-- LEGACY is the current key layout; the legacy lease hashes are removed
+	-- too until the workspace is migrated to it
+	if LEGACY and META_KEY then
+		local reply = redis.call('HGET', META_KEY, "layout")
+		if type(reply)=='table' and reply.err then
+			return reply
+		end
+		if tonumber(reply) and tonumber(reply) >= LEGACY then
+			LEGACY = nil
+		end
+	end
 
 	local LEASE_REPLY, COUNT
 
@@ -262,23 +564,60 @@ if TIMESTAMP and SINK and WORKSPACE then
 
 			local lease     = LEASE_REPLY[i]
 			local expire_at = LEASE_REPLY[i+1]
+			local lease_key = PREFIX .. WORKSPACE .. ":" .. lease
+			local metadata, revision, traceparent
 
 			do
-				local reply  = redis.call('XADD', SINK, '*'
-																				, "action"   , 'EXPIRED'
-																				, "workspace", WORKSPACE
-																				, "lease"    , lease
-																				, "expire_at", expire_at)
+				local reply  = redis.call('HMGET', lease_key
+																				, "metadata"
+																				, "revision"
+																				, "traceparent")
+				if type(reply)=='table' and reply.err then
+					return reply
+				end
+				metadata, revision, traceparent = unpack(reply)
+			end
+			do
+				local fields = {
+					"action"   , 'EXPIRED',
+					"workspace", WORKSPACE,
+					"lease"    , lease,
+					"expire_at", expire_at,
+				}
+				if revision then
+					table.insert(fields, "revision")
+					table.insert(fields, revision)
+				end
+				if metadata then
+					table.insert(fields, "metadata")
+					table.insert(fields, metadata)
+				end
+				if traceparent then
+					table.insert(fields, "traceparent")
+					table.insert(fields, traceparent)
+				end
+
+				local reply  = redis.call('XADD', SINK, '*', unpack(fields))
 				if type(reply)=='table' and reply.err then
 					return reply
 				end
 			end
 			if expire_at then
-				local reply  = redis.call('DEL', lease)
+				local reply  = redis.call('DEL', lease_key)
 				if type(reply)=='table' and reply.err then
 					return reply
 				end
 			end
+			if LEGACY and lease ~= lease_key then
+				local legacy = redis.call('TYPE', lease)
+				if type(legacy)=='table' and legacy.ok == 'hash' and
+					redis.call('HEXISTS', lease, "ttl") == 1 then
+					local reply = redis.call('DEL', lease)
+					if type(reply)=='table' and reply.err then
+						return reply
+					end
+				end
+			end
 			do
 				local reply  = redis.call('ZREM', WORKSPACE, lease)
 				if type(reply)=='table' and reply.err then
@@ -291,6 +630,354 @@ if TIMESTAMP and SINK and WORKSPACE then
 	end
 
 	RESULT = COUNT or 0
+
+	-- retention of the sink
+	if (MAXLEN and MAXLEN > 0) or (MINID_AGE and MINID_AGE > 0) then
+		local TRIMMED, UNDELIVERED, PENDING = 0, {}, {}
+		local NEXT_UNDELIVERED, OLDEST_PENDING = {}, {}
+		local FIRST_BEFORE
+
+		local exists = redis.call('EXISTS', SINK)
+		if type(exists)=='table' and exists.err then
+			return exists
+		end
+
+		-- the first entry each consumer group has not been delivered yet, and
+		-- the oldest one it has not acknowledged yet
+		if exists == 1 then
+			-- the pending IDs of the entries trimmed already are skipped
+			do
+				local reply = redis.call('XRANGE', SINK, '-', '+', 'COUNT', 1)
+				if type(reply)=='table' and reply.err then
+					return reply
+				end
+				if #reply > 0 then
+					FIRST_BEFORE = reply[1][1]
+				end
+			end
+
+			local reply = redis.call('XINFO', 'GROUPS', SINK)
+			if type(reply)=='table' and reply.err then
+				return reply
+			end
+			for _, group in ipairs(reply) do
+				local name, last
+				for i = 1, #group, 2 do
+					if group[i] == 'name' then
+						name = group[i+1]
+					elseif group[i] == 'last-delivered-id' then
+						last = group[i+1]
+					end
+				end
+				if name and last then
+					local entries = redis.call('XRANGE', SINK, last, '+', 'COUNT', 2)
+					if type(entries)=='table' and entries.err then
+						return entries
+					end
+					for _, entry in ipairs(entries) do
+						if entry[1] ~= last then
+							table.insert(NEXT_UNDELIVERED, { name, entry[1] })
+							break
+						end
+					end
+				end
+				if name then
+					local pending = redis.call('XPENDING', SINK, name, FIRST_BEFORE or '-', '+', 1)
+					if type(pending)=='table' and pending.err then
+						return pending
+					end
+					if #pending > 0 then
+						table.insert(OLDEST_PENDING, { name, pending[1][1] })
+					end
+				end
+			end
+		end
+
+		if MAXLEN and MAXLEN > 0 then
+			local reply = redis.call('XTRIM', SINK, 'MAXLEN', '~', MAXLEN)
+			if type(reply)=='table' and reply.err then
+				return reply
+			end
+			TRIMMED = TRIMMED + reply
+		end
+		if MINID_AGE and MINID_AGE > 0 then
+			local reply = redis.call('XTRIM', SINK, 'MINID', '~', tostring(TIMESTAMP - MINID_AGE))
+			if type(reply)=='table' and reply.err then
+				return reply
+			end
+			TRIMMED = TRIMMED + reply
+		end
+
+		if TRIMMED > 0 and (#NEXT_UNDELIVERED > 0 or #OLDEST_PENDING > 0) then
+			local first
+			do
+				local reply = redis.call('XRANGE', SINK, '-', '+', 'COUNT', 1)
+				if type(reply)=='table' and reply.err then
+					return reply
+				end
+				if #reply > 0 then
+					first = reply[1][1]
+				end
+			end
+			for _, v in ipairs(NEXT_UNDELIVERED) do
+				if not first or stream_id_less(v[2], first) then
+					table.insert(UNDELIVERED, v[1])
+				end
+			end
+			for _, v in ipairs(OLDEST_PENDING) do
+				if not first or stream_id_less(v[2], first) then
+					table.insert(PENDING, v[1])
+				end
+			end
+		end
+
+		RESULT = { RESULT, TRIMMED, UNDELIVERED, PENDING }
+	end
+end
+return RESULT`
+
+	LEASE_LUA_LIST  = "list"
+	LUA_SCRIPT_LIST = `
+if #KEYS < 1 then
+	return redis.error_reply("ILLEGAL_ARGUMENTS")
+end
+
+local WORKSPACE = KEYS[1]
+
+local MIN, MAX, AFTER_SCORE, AFTER_ID, MATCH, LIMIT, DETAILS, PREFIX, SCAN
+
+if ARGV then
+	if #ARGV % 2 ~= 0 then
+		return redis.error_reply("ILLEGAL_ARGUMENTS")
+	end
+
+	local ARGV_SETTER = {
+		MIN         = function(v) MIN         = tonumber(v) end,
+		MAX         = function(v) MAX         = tonumber(v) end,
+		AFTER_SCORE = function(v) AFTER_SCORE = tonumber(v) end,
+		AFTER_ID    = function(v) AFTER_ID    = v           end,
+		MATCH       = function(v) MATCH       = v           end,
+		LIMIT       = function(v) LIMIT       = tonumber(v) end,
+		DETAILS     = function(v) DETAILS     = (v == "1")  end,
+		PREFIX      = function(v) PREFIX      = v           end,
+		SCAN        = function(v) SCAN        = tonumber(v) end,
+	}
+
+	for i = 1, #ARGV, 2 do
+		local k = ARGV[i]
+		local setter = ARGV_SETTER[k]
+		if setter then
+			local err = setter(ARGV[i+1])
+			if err then
+				return err
+			end
+		end
+	end
+end
+
+if WORKSPACE == "" then  return redis.error_reply("INVALID_ARGUMENT")  end
+if not LIMIT  or  LIMIT <= 0 then  return redis.error_reply("INVALID_ARGUMENT")  end
+if not PREFIX then
+	PREFIX = ""
+end
+if not SCAN  or  SCAN < LIMIT then
+	SCAN = LIMIT
+end
+
+-- the rank to start from: right after the last visited lease if it is still
+-- at the score of the cursor, otherwise the first lease of that score, from
+-- which the leases visited already are scanned again but skipped
+local RANK = 0
+if AFTER_SCORE and AFTER_ID then
+	local score = redis.call('ZSCORE', WORKSPACE, AFTER_ID)
+	if type(score)=='table' and score.err then
+		return score
+	end
+	if score and tonumber(score) == AFTER_SCORE then
+		local reply = redis.call('ZRANK', WORKSPACE, AFTER_ID)
+		if type(reply)=='table' and reply.err then
+			return reply
+		end
+		RANK = reply + 1
+	else
+		local reply = redis.call('ZCOUNT', WORKSPACE, '-inf', '(' .. string.format('%.17g', AFTER_SCORE))
+		if type(reply)=='table' and reply.err then
+			return reply
+		end
+		RANK = reply
+	end
+elseif MIN then
+	local reply = redis.call('ZCOUNT', WORKSPACE, '-inf', '(' .. string.format('%.17g', MIN))
+	if type(reply)=='table' and reply.err then
+		return reply
+	end
+	RANK = reply
+end
+
+-- the result starts with the cursor, the score and the ID of the last
+-- visited lease, or empty strings if there are no more leases
+local RESULT = { "", "" }
+local COUNT, SCANNED = 0, 0
+local LAST_SCORE, LAST_ID
+local CHUNK = math.min(SCAN, 1000)
+
+while true do
+	local reply = redis.call('ZRANGE', WORKSPACE, RANK, RANK + CHUNK - 1, 'WITHSCORES')
+	if type(reply)=='table' and reply.err then
+		return reply
+	end
+
+	for i = 1, #reply, 2 do
+		local lease     = reply[i]
+		local expire_at = reply[i+1]
+		local score     = tonumber(expire_at)
+
+		if MAX and score > MAX then
+			return RESULT
+		end
+
+		-- the leases visited already count against the scan too
+		SCANNED = SCANNED + 1
+
+		local visited = AFTER_SCORE and AFTER_ID and score == AFTER_SCORE and lease <= AFTER_ID
+		if not visited and (not MATCH or string.sub(lease, 1, #MATCH) == MATCH) then
+			-- a lease is left past the page, so the listing goes on right
+			-- after the last scanned lease
+			if COUNT >= LIMIT then
+				RESULT[1] = LAST_SCORE
+				RESULT[2] = LAST_ID
+				return RESULT
+			end
+
+			local details = ""
+			if DETAILS then
+				local values = redis.call('HMGET', PREFIX .. WORKSPACE .. ":" .. lease
+																					, "ttl"
+																					, "timestamp"
+																					, "metadata"
+																					, "revision")
+				if type(values)=='table' and values.err then
+					return values
+				end
+				local ttl, timestamp, metadata, revision = unpack(values)
+				local result = {
+					ttl       = tonumber(ttl),
+					timestamp = tonumber(timestamp),
+					expire_at = score,
+					revision  = tonumber(revision),
+				}
+				if metadata then
+					local ok, decoded = pcall(cjson.decode, metadata)
+					if ok and type(decoded)=='table' and next(decoded) then
+						result.metadata = decoded
+					end
+				end
+				details = cmsgpack.pack(result)
+			end
+
+			table.insert(RESULT, lease)
+			table.insert(RESULT, expire_at)
+			table.insert(RESULT, details)
+			COUNT = COUNT + 1
+		end
+		LAST_SCORE = expire_at
+		LAST_ID    = lease
+
+		-- the scan runs out; the listing goes on unless no lease is left
+		if SCANNED >= SCAN then
+			local next_score = reply[i+3]
+			if not next_score and #reply == CHUNK * 2 then
+				local peek = redis.call('ZRANGE', WORKSPACE, RANK + CHUNK, RANK + CHUNK, 'WITHSCORES')
+				if type(peek)=='table' and peek.err then
+					return peek
+				end
+				next_score = peek[2]
+			end
+			if next_score and not (MAX and tonumber(next_score) > MAX) then
+				RESULT[1] = expire_at
+				RESULT[2] = lease
+			end
+			return RESULT
+		end
+	end
+
+	if #reply < CHUNK * 2 then
+		return RESULT
+	end
+	RANK = RANK + CHUNK
+end`
+
+	LEASE_LUA_MIGRATE  = "migrate"
+	LUA_SCRIPT_MIGRATE = `
+if #KEYS < 2 then
+	return redis.error_reply("ILLEGAL_ARGUMENTS")
+end
+
+local WORKSPACE = KEYS[1]
+local META_KEY  = KEYS[2]
+local PREFIX    = ARGV[1]
+local VERSION   = tonumber(ARGV[2])
+local CURSOR    = ARGV[3]
+local COUNT     = tonumber(ARGV[4])
+
+local RESULT
+if COUNT and CURSOR and VERSION and PREFIX and META_KEY and WORKSPACE then
+	if WORKSPACE == "" then  return redis.error_reply("INVALID_ARGUMENT")  end
+	if COUNT     <= 0  then  return redis.error_reply("INVALID_ARGUMENT")  end
+
+	do
+		local reply = redis.call('HGET', META_KEY, "layout")
+		if type(reply)=='table' and reply.err then
+			return reply
+		end
+		if tonumber(reply) and tonumber(reply) >= VERSION then
+			return { "0", 0 }
+		end
+	end
+
+	local LEASE_REPLY, MIGRATED, NEXT_CURSOR
+
+	-- ZSCAN returns every lease present during the whole scan at least once,
+	-- whatever is granted or expired between the calls
+	do
+		local reply = redis.call('ZSCAN', WORKSPACE, CURSOR, 'COUNT', COUNT)
+		if type(reply)=='table' and reply.err then
+			return reply
+		end
+		NEXT_CURSOR = reply[1]
+		LEASE_REPLY = {}
+		for i = 1, #reply[2], 2 do
+			table.insert(LEASE_REPLY, reply[2][i])
+		end
+	end
+
+	MIGRATED = 0
+	for i = 1, #LEASE_REPLY do
+		local lease     = LEASE_REPLY[i]
+		local lease_key = PREFIX .. WORKSPACE .. ":" .. lease
+
+		if lease ~= lease_key then
+			local legacy = redis.call('TYPE', lease)
+			if type(legacy)=='table' and legacy.ok == 'hash' and
+				redis.call('HEXISTS', lease, "ttl") == 1 and
+				redis.call('EXISTS', lease_key) == 0 then
+				local reply = redis.call('RENAME', lease, lease_key)
+				if type(reply)=='table' and reply.err then
+					return reply
+				end
+				MIGRATED = MIGRATED + 1
+			end
+		end
+	end
+
+	if NEXT_CURSOR == "0" then
+		local reply = redis.call('HSET', META_KEY, "layout", VERSION)
+		if type(reply)=='table' and reply.err then
+			return reply
+		end
+	end
+
+	RESULT = { NEXT_CURSOR, MIGRATED }
 end
 return RESULT`
 )
@@ -300,37 +987,42 @@ var (
 
 	LeaseScriptList   map[string]string
 	LeaseScriptIDList map[string]string
+
+	leaseScriptIDMutex sync.RWMutex
 )
 
 func init() {
 	LeaseScriptList = map[string]string{
-		LEASE_LUA_PUT:    LUA_SCRIPT_PUT,
-		LEASE_LUA_GET:    LUA_SCRIPT_GET,
-		LEASE_LUA_DELETE: LUA_SCRIPT_DELETE,
-		LEASE_LUA_RENEW:  LUA_SCRIPT_RENEW,
-		LEASE_LUA_EXPIRE: LUA_SCRIPT_EXPIRE,
+		LEASE_LUA_PUT:     LUA_SCRIPT_PUT,
+		LEASE_LUA_GET:     LUA_SCRIPT_GET,
+		LEASE_LUA_DELETE:  LUA_SCRIPT_DELETE,
+		LEASE_LUA_RENEW:   LUA_SCRIPT_RENEW,
+		LEASE_LUA_EXPIRE:  LUA_SCRIPT_EXPIRE,
+		LEASE_LUA_MIGRATE: LUA_SCRIPT_MIGRATE,
+		LEASE_LUA_LIST:    LUA_SCRIPT_LIST,
 	}
 
 	LeaseScriptIDList = make(map[string]string)
 }
 
+type LeaseScriptCall struct {
+	Keys []string
+	Args []interface{}
+}
+
 type LeaseScript struct{}
 
 func (s *LeaseScript) getScriptID(client redis.UniversalClient, name string) (string, error) {
+	leaseScriptIDMutex.RLock()
 	id, ok := LeaseScriptIDList[name]
+	leaseScriptIDMutex.RUnlock()
+
 	if !ok {
 		return s.registerScript(client, name)
 	}
 	if len(id) == 0 {
 		return s.registerScript(client, name)
 	}
-	reply, err := client.ScriptExists(id).Result()
-	if err != nil {
-		return "", err
-	}
-	if (len(reply) < 1) || (reply[0] == false) {
-		return s.registerScript(client, name)
-	}
 	return id, nil
 }
 
@@ -341,13 +1033,22 @@ func (s *LeaseScript) registerScript(client redis.UniversalClient, name string)
 			return "", err
 		}
 		// update script id list
+		leaseScriptIDMutex.Lock()
 		LeaseScriptIDList[name] = id
+		leaseScriptIDMutex.Unlock()
 		return id, nil
 	}
 	return "", fmt.Errorf("cannot find script '%s'", name)
 }
 
 func (s *LeaseScript) Exec(client redis.UniversalClient, name string, keys []string, args ...interface{}) (interface{}, error) {
+	return s.ExecContext(context.Background(), client, name, keys, args...)
+}
+
+func (s *LeaseScript) ExecContext(ctx context.Context, client redis.UniversalClient, name string, keys []string, args ...interface{}) (interface{}, error) {
+	if ctx == nil {
+		panic("specified argument 'ctx' cannot be nil")
+	}
 	if client == nil {
 		panic("specified argument 'client' cannot be nil")
 	}
@@ -355,9 +1056,97 @@ func (s *LeaseScript) Exec(client redis.UniversalClient, name string, keys []str
 		panic("specified argument 'name' cannot be an empty string")
 	}
 
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	client = helper.WithContext(client, ctx)
+
 	id, err := s.getScriptID(client, name)
 	if err != nil {
 		return nil, err
 	}
-	return client.EvalSha(id, keys, args...).Result()
+
+	reply, err := client.EvalSha(id, keys, args...).Result()
+	if isNoScriptError(err) {
+		// the script cache of the server has been flushed
+		id, err := s.registerScript(client, name)
+		if err != nil {
+			return nil, err
+		}
+		return client.EvalSha(id, keys, args...).Result()
+	}
+	return reply, err
+}
+
+// ExecManyContext executes the specified script once for each call in a
+// single pipeline. The result and the error of each call are carried by the
+// corresponding *redis.Cmd.
+func (s *LeaseScript) ExecManyContext(ctx context.Context, client redis.UniversalClient, name string, calls []*LeaseScriptCall) ([]*redis.Cmd, error) {
+	if ctx == nil {
+		panic("specified argument 'ctx' cannot be nil")
+	}
+	if client == nil {
+		panic("specified argument 'client' cannot be nil")
+	}
+	if len(name) == 0 {
+		panic("specified argument 'name' cannot be an empty string")
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if len(calls) == 0 {
+		return nil, nil
+	}
+
+	client = helper.WithContext(client, ctx)
+
+	id, err := s.getScriptID(client, name)
+	if err != nil {
+		return nil, err
+	}
+
+	cmds := s.evalShaPipelined(client, id, calls)
+
+	var retries []int
+	for i, cmd := range cmds {
+		if isNoScriptError(cmd.Err()) {
+			retries = append(retries, i)
+		}
+	}
+	if len(retries) > 0 {
+		// the script cache of the server has been flushed
+		id, err := s.registerScript(client, name)
+		if err != nil {
+			return nil, err
+		}
+
+		var retryCalls = make([]*LeaseScriptCall, len(retries))
+		for i, index := range retries {
+			retryCalls[i] = calls[index]
+		}
+		for i, cmd := range s.evalShaPipelined(client, id, retryCalls) {
+			cmds[retries[i]] = cmd
+		}
+	}
+	return cmds, nil
+}
+
+func (s *LeaseScript) evalShaPipelined(client redis.UniversalClient, id string, calls []*LeaseScriptCall) []*redis.Cmd {
+	var (
+		pipe = client.Pipeline()
+		cmds = make([]*redis.Cmd, len(calls))
+	)
+	for i, call := range calls {
+		cmds[i] = pipe.EvalSha(id, call.Keys, call.Args...)
+	}
+	// NOTE: the errors are carried by each command
+	pipe.Exec()
+	pipe.Close()
+	return cmds
+}
+
+func isNoScriptError(err error) bool {
+	return err != nil && strings.HasPrefix(err.Error(), "NOSCRIPT ")
 }
@@ -0,0 +1,230 @@
+package internal
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DEFAULT_LIST_LIMIT int64 = 100
+
+	// LIST_SCAN_FACTOR bounds the leases visited by one List call to this
+	// multiple of the limit, so that a selective prefix does not block the
+	// store; a page may then be shorter than the limit, and have a Cursor
+	// even if no more leases match.
+	LIST_SCAN_FACTOR int64 = 10
+)
+
+var (
+	ErrInvalidCursor = errors.New("invalid list cursor")
+
+	// ErrStaleRenewal is returned with the current expiry of a lease by the
+	// renewals not later than the last one, which leave the lease as is.
+	ErrStaleRenewal = errors.New("stale lease renewal")
+)
+
+var (
+	_ LeaseStore         = new(LeaseProvider)
+	_ LeaseBatchStore    = new(LeaseProvider)
+	_ LeaseMigrator      = new(LeaseProvider)
+	_ LeaseLister        = new(LeaseProvider)
+	_ LeaseSinkTrimmer   = new(LeaseProvider)
+	_ LeasePager         = new(LeaseProvider)
+	_ LeaseRevisionStore = new(LeaseProvider)
+)
+
+type (
+	LeaseStore interface {
+		PutContext(ctx context.Context, workspace, lease string, ttl time.Duration, timestamp time.Time, options ...*LeaseArg) (ok bool, err error)
+		GetContext(ctx context.Context, workspace, lease string) (*Lease, error)
+		DeleteContext(ctx context.Context, workspace, lease string, options ...*LeaseArg) (ok bool, err error)
+		RenewContext(ctx context.Context, workspace, lease string, timestamp time.Time, options ...*LeaseArg) (Timestamp, error)
+		ExpireContext(ctx context.Context, workspace, sink string, timestamp time.Time, options ...*LeaseArg) (count int64, err error)
+	}
+
+	LeaseBatchStore interface {
+		PutManyContext(ctx context.Context, workspace string, leases []*Lease, timestamp time.Time, options ...*LeaseArg) ([]bool, []error, error)
+		GetManyContext(ctx context.Context, workspace string, leases []string) ([]*Lease, []error, error)
+		DeleteManyContext(ctx context.Context, workspace string, leases []string, options ...*LeaseArg) ([]bool, []error, error)
+		RenewManyContext(ctx context.Context, workspace string, leases []string, timestamp time.Time, options ...*LeaseArg) ([]Timestamp, []error, error)
+	}
+
+	// LeaseRevisionStore puts leases like PutContext and PutManyContext and
+	// returns their revisions, which are strictly increasing within the
+	// workspace. A zero revision means the lease was not put.
+	LeaseRevisionStore interface {
+		PutWithRevisionContext(ctx context.Context, workspace, lease string, ttl time.Duration, timestamp time.Time, options ...*LeaseArg) (revision int64, err error)
+		PutManyWithRevisionContext(ctx context.Context, workspace string, leases []*Lease, timestamp time.Time, options ...*LeaseArg) ([]int64, []error, error)
+	}
+
+	LeaseMigrator interface {
+		MigrateContext(ctx context.Context, workspace string, batchSize int64) (count int64, err error)
+	}
+
+	LeaseScriptHook interface {
+		OnExec(script string, elapsed time.Duration, err error)
+	}
+
+	LeaseLister interface {
+		ListAliveContext(ctx context.Context, workspace string, timestamp time.Time) ([]string, error)
+	}
+
+	// LeasePager lists the leases of a workspace a page at a time, resuming
+	// from the Cursor of the previous LeasePage.
+	LeasePager interface {
+		ListContext(ctx context.Context, workspace string, opts ListOptions) (*LeasePage, error)
+	}
+
+	// LeaseSinkTrimmer expires leases like ExpireContext, then trims the sink
+	// by the LEASE_ARG_MAXLEN and LEASE_ARG_MINID_AGE options in the same
+	// atomic step.
+	LeaseSinkTrimmer interface {
+		ExpireAndTrimContext(ctx context.Context, workspace, sink string, timestamp time.Time, options ...*LeaseArg) (count int64, trim SinkTrimResult, err error)
+	}
+)
+
+// ListOptions selects the leases of a List call. The leases are ordered by
+// ExpireAt, then by ID. A zero MinExpireAt or MaxExpireAt leaves the range
+// open, and WithDetails fills the TTL, timestamp, metadata and revision.
+type ListOptions struct {
+	MinExpireAt Timestamp
+	MaxExpireAt Timestamp
+	Prefix      string
+	Cursor      string
+	Limit       int64
+	WithDetails bool
+}
+
+// LeasePage is a page of a List call. Cursor continues the listing, and is
+// empty on the last page.
+type LeasePage struct {
+	Leases []*Lease
+	Cursor string
+}
+
+// listCursor is the position of the last visited lease.
+type listCursor struct {
+	expireAt int64
+	lease    string
+}
+
+func (c listCursor) String() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(c.expireAt, 10) + ":" + c.lease))
+}
+
+func parseListCursor(cursor string) (*listCursor, error) {
+	if len(cursor) == 0 {
+		return nil, nil
+	}
+
+	buf, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	parts := strings.SplitN(string(buf), ":", 2)
+	if len(parts) != 2 {
+		return nil, ErrInvalidCursor
+	}
+	expireAt, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return &listCursor{expireAt: expireAt, lease: parts[1]}, nil
+}
+
+// SinkTrimResult reports the entries trimmed from a sink, the consumer
+// groups which had not been delivered some of them yet, and the ones which
+// had not acknowledged some of them yet.
+type SinkTrimResult struct {
+	Trimmed           int64
+	UndeliveredGroups []string
+	PendingGroups     []string
+}
+
+// PutWithRevision puts the lease and returns its revision if the store is a
+// LeaseRevisionStore, or a zero revision otherwise.
+func PutWithRevision(ctx context.Context, store LeaseStore, workspace, lease string, ttl time.Duration, timestamp time.Time, options ...*LeaseArg) (ok bool, revision int64, err error) {
+	if s, ok := store.(LeaseRevisionStore); ok {
+		revision, err = s.PutWithRevisionContext(ctx, workspace, lease, ttl, timestamp, options...)
+		return revision > 0, revision, err
+	}
+	ok, err = store.PutContext(ctx, workspace, lease, ttl, timestamp, options...)
+	return ok, 0, err
+}
+
+func PutMany(ctx context.Context, store LeaseStore, workspace string, leases []*Lease, timestamp time.Time, options ...*LeaseArg) ([]bool, []error, error) {
+	if s, ok := store.(LeaseBatchStore); ok {
+		return s.PutManyContext(ctx, workspace, leases, timestamp, options...)
+	}
+
+	var (
+		results = make([]bool, len(leases))
+		errs    = make([]error, len(leases))
+	)
+	for i, lease := range leases {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+		args := append(options[:len(options):len(options)], MetadataArg(lease.Metadata))
+		results[i], errs[i] = store.PutContext(ctx, workspace, lease.ID, lease.TTL, timestamp, args...)
+	}
+	return results, errs, nil
+}
+
+func GetMany(ctx context.Context, store LeaseStore, workspace string, leases []string) ([]*Lease, []error, error) {
+	if s, ok := store.(LeaseBatchStore); ok {
+		return s.GetManyContext(ctx, workspace, leases)
+	}
+
+	var (
+		results = make([]*Lease, len(leases))
+		errs    = make([]error, len(leases))
+	)
+	for i, lease := range leases {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+		results[i], errs[i] = store.GetContext(ctx, workspace, lease)
+	}
+	return results, errs, nil
+}
+
+func DeleteMany(ctx context.Context, store LeaseStore, workspace string, leases []string, options ...*LeaseArg) ([]bool, []error, error) {
+	if s, ok := store.(LeaseBatchStore); ok {
+		return s.DeleteManyContext(ctx, workspace, leases, options...)
+	}
+
+	var (
+		results = make([]bool, len(leases))
+		errs    = make([]error, len(leases))
+	)
+	for i, lease := range leases {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+		results[i], errs[i] = store.DeleteContext(ctx, workspace, lease, options...)
+	}
+	return results, errs, nil
+}
+
+func RenewMany(ctx context.Context, store LeaseStore, workspace string, leases []string, timestamp time.Time, options ...*LeaseArg) ([]Timestamp, []error, error) {
+	if s, ok := store.(LeaseBatchStore); ok {
+		return s.RenewManyContext(ctx, workspace, leases, timestamp, options...)
+	}
+
+	var (
+		results = make([]Timestamp, len(leases))
+		errs    = make([]error, len(leases))
+	)
+	for i, lease := range leases {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+		results[i], errs[i] = store.RenewContext(ctx, workspace, lease, timestamp, options...)
+	}
+	return results, errs, nil
+}
@@ -0,0 +1,461 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	redis "github.com/go-redis/redis/v7"
+)
+
+var (
+	_ LeaseStore         = new(MemoryLeaseStore)
+	_ LeaseLister        = new(MemoryLeaseStore)
+	_ LeaseSinkTrimmer   = new(MemoryLeaseStore)
+	_ LeasePager         = new(MemoryLeaseStore)
+	_ LeaseRevisionStore = new(MemoryLeaseStore)
+)
+
+type memoryLease struct {
+	ttl         int64
+	timestamp   int64
+	expireAt    int64
+	metadata    string
+	owner       string
+	revision    int64
+	traceparent string
+}
+
+func (r *memoryLease) toLease(id string, details bool) *Lease {
+	expireAt := Timestamp(r.expireAt)
+	result := &Lease{
+		ID:       id,
+		ExpireAt: &expireAt,
+	}
+	if !details {
+		return result
+	}
+
+	result.TTL = time.Duration(r.ttl) * time.Millisecond
+	result.Timestamp = Timestamp(r.timestamp)
+	result.Revision = r.revision
+	if len(r.metadata) > 0 {
+		var metadata map[string]string
+		if err := json.Unmarshal([]byte(r.metadata), &metadata); err == nil && len(metadata) > 0 {
+			result.Metadata = metadata
+		}
+	}
+	return result
+}
+
+// MemoryLeaseStore is a LeaseStore which keeps leases and events in process
+// memory. It follows the semantics of the Redis lease scripts, and appends the
+// events to in-memory sinks in the same shape as the Redis stream entries.
+type MemoryLeaseStore struct {
+	mutex      sync.Mutex
+	workspaces map[string]map[string]*memoryLease
+	revisions  map[string]int64
+	sinks      map[string][]redis.XMessage
+
+	lastEventTime int64
+	lastEventSeq  int64
+}
+
+func NewMemoryLeaseStore() *MemoryLeaseStore {
+	return new(MemoryLeaseStore)
+}
+
+func (s *MemoryLeaseStore) PutContext(ctx context.Context, workspace, lease string, ttl time.Duration, timestamp time.Time, options ...*LeaseArg) (ok bool, err error) {
+	revision, err := s.PutWithRevisionContext(ctx, workspace, lease, ttl, timestamp, options...)
+	return revision > 0, err
+}
+
+func (s *MemoryLeaseStore) PutWithRevisionContext(ctx context.Context, workspace, lease string, ttl time.Duration, timestamp time.Time, options ...*LeaseArg) (revision int64, err error) {
+	if err := s.validate(ctx, workspace, lease); err != nil {
+		return 0, err
+	}
+
+	var (
+		ttl_ms       int64 = ttl.Milliseconds()
+		timestamp_ms int64 = timestamp.UnixNano() / int64(time.Millisecond)
+	)
+	if ttl_ms <= 0 {
+		return 0, nil
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	leases := s.workspace(workspace)
+	if last, ok := leases[lease]; ok {
+		if nx, _ := lookupLeaseArg(options, LEASE_ARG_NX); nx == "1" && last.expireAt > timestamp_ms {
+			return 0, nil
+		}
+		if timestamp_ms <= last.timestamp {
+			return 0, nil
+		}
+	}
+
+	if s.revisions == nil {
+		s.revisions = make(map[string]int64)
+	}
+	s.revisions[workspace]++
+
+	metadata, _ := lookupLeaseArg(options, LEASE_ARG_METADATA)
+	owner, _ := lookupLeaseArg(options, LEASE_ARG_OWNER)
+	traceparent, _ := lookupLeaseArg(options, LEASE_ARG_TRACEPARENT)
+	record := &memoryLease{
+		ttl:         ttl_ms,
+		timestamp:   timestamp_ms,
+		expireAt:    timestamp_ms + ttl_ms,
+		metadata:    metadata,
+		owner:       owner,
+		revision:    s.revisions[workspace],
+		traceparent: traceparent,
+	}
+	leases[lease] = record
+
+	if sink, ok := lookupLeaseArg(options, LEASE_ARG_SINK); ok && len(sink) > 0 {
+		s.appendEvent(sink, "GRANTED", workspace, lease, record)
+	}
+	return record.revision, nil
+}
+
+func (s *MemoryLeaseStore) PutManyWithRevisionContext(ctx context.Context, workspace string, leases []*Lease, timestamp time.Time, options ...*LeaseArg) ([]int64, []error, error) {
+	var (
+		results = make([]int64, len(leases))
+		errs    = make([]error, len(leases))
+	)
+	for i, lease := range leases {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+		args := append(options[:len(options):len(options)], MetadataArg(lease.Metadata))
+		results[i], errs[i] = s.PutWithRevisionContext(ctx, workspace, lease.ID, lease.TTL, timestamp, args...)
+	}
+	return results, errs, nil
+}
+
+func (s *MemoryLeaseStore) GetContext(ctx context.Context, workspace, lease string) (*Lease, error) {
+	if err := s.validate(ctx, workspace, lease); err != nil {
+		return nil, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	record, ok := s.workspace(workspace)[lease]
+	if !ok {
+		return nil, nil
+	}
+	return record.toLease(lease, true), nil
+}
+
+func (s *MemoryLeaseStore) DeleteContext(ctx context.Context, workspace, lease string, options ...*LeaseArg) (ok bool, err error) {
+	if err := s.validate(ctx, workspace, lease); err != nil {
+		return false, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	leases := s.workspace(workspace)
+	record, ok := leases[lease]
+	if !ok {
+		return false, nil
+	}
+	if owner, _ := lookupLeaseArg(options, LEASE_ARG_OWNER); owner != record.owner {
+		return false, nil
+	}
+	delete(leases, lease)
+
+	if sink, ok := lookupLeaseArg(options, LEASE_ARG_SINK); ok && len(sink) > 0 {
+		s.appendEvent(sink, "REVOKED", workspace, lease, record)
+	}
+	return true, nil
+}
+
+func (s *MemoryLeaseStore) RenewContext(ctx context.Context, workspace, lease string, timestamp time.Time, options ...*LeaseArg) (Timestamp, error) {
+	if err := s.validate(ctx, workspace, lease); err != nil {
+		return 0, err
+	}
+
+	var (
+		timestamp_ms int64 = timestamp.UnixNano() / int64(time.Millisecond)
+	)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	record, ok := s.workspace(workspace)[lease]
+	if !ok {
+		return 0, nil
+	}
+	if owner, _ := lookupLeaseArg(options, LEASE_ARG_OWNER); owner != record.owner {
+		return 0, nil
+	}
+	if timestamp_ms <= record.timestamp {
+		return Timestamp(record.expireAt), ErrStaleRenewal
+	}
+	record.timestamp = timestamp_ms
+	record.expireAt = timestamp_ms + record.ttl
+
+	if sink, ok := lookupLeaseArg(options, LEASE_ARG_SINK); ok && len(sink) > 0 {
+		s.appendEvent(sink, "RENEWED", workspace, lease, record)
+	}
+	return Timestamp(record.expireAt), nil
+}
+
+func (s *MemoryLeaseStore) ExpireContext(ctx context.Context, workspace, sink string, timestamp time.Time, options ...*LeaseArg) (count int64, err error) {
+	count, _, err = s.ExpireAndTrimContext(ctx, workspace, sink, timestamp, options...)
+	return count, err
+}
+
+// ExpireAndTrimContext trims the sink exactly; the in-memory sinks have no
+// consumer groups, so no undelivered or pending groups are reported.
+func (s *MemoryLeaseStore) ExpireAndTrimContext(ctx context.Context, workspace, sink string, timestamp time.Time, options ...*LeaseArg) (count int64, trim SinkTrimResult, err error) {
+	if err := ctx.Err(); err != nil {
+		return 0, trim, err
+	}
+	if len(workspace) == 0 || len(sink) == 0 {
+		return 0, trim, fmt.Errorf("INVALID_ARGUMENT")
+	}
+
+	var (
+		timestamp_ms int64 = timestamp.UnixNano() / int64(time.Millisecond)
+		limit        int64
+	)
+	if v, ok := lookupLeaseArg(options, "LIMIT"); ok {
+		limit, _ = strconv.ParseInt(v, 10, 64)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	leases := s.workspace(workspace)
+
+	var expired []string
+	for id, record := range leases {
+		if record.expireAt <= timestamp_ms {
+			expired = append(expired, id)
+		}
+	}
+	// keep the order of the sorted set: by score, then lexicographically
+	sort.Slice(expired, func(i, j int) bool {
+		a, b := leases[expired[i]], leases[expired[j]]
+		if a.expireAt != b.expireAt {
+			return a.expireAt < b.expireAt
+		}
+		return expired[i] < expired[j]
+	})
+	if limit > 0 && int64(len(expired)) > limit {
+		expired = expired[:limit]
+	}
+
+	for _, id := range expired {
+		s.appendEvent(sink, "EXPIRED", workspace, id, leases[id])
+		delete(leases, id)
+	}
+
+	trim.Trimmed = s.trimSink(sink, timestamp_ms, options)
+	return int64(len(expired)), trim, nil
+}
+
+func (s *MemoryLeaseStore) trimSink(sink string, timestamp_ms int64, options []*LeaseArg) int64 {
+	var (
+		messages = s.sinks[sink]
+		offset   int
+	)
+	if v, ok := lookupLeaseArg(options, LEASE_ARG_MAXLEN); ok {
+		if maxlen, _ := strconv.Atoi(v); maxlen > 0 && len(messages) > maxlen {
+			offset = len(messages) - maxlen
+		}
+	}
+	if v, ok := lookupLeaseArg(options, LEASE_ARG_MINID_AGE); ok {
+		if age, _ := strconv.ParseInt(v, 10, 64); age > 0 {
+			for offset < len(messages) {
+				ms, _ := strconv.ParseInt(strings.SplitN(messages[offset].ID, "-", 2)[0], 10, 64)
+				if ms >= timestamp_ms-age {
+					break
+				}
+				offset++
+			}
+		}
+	}
+	if offset > 0 {
+		s.sinks[sink] = append([]redis.XMessage(nil), messages[offset:]...)
+	}
+	return int64(offset)
+}
+
+func (s *MemoryLeaseStore) ListAliveContext(ctx context.Context, workspace string, timestamp time.Time) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var (
+		timestamp_ms int64 = timestamp.UnixNano() / int64(time.Millisecond)
+	)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	leases := s.workspace(workspace)
+
+	var alive []string
+	for id, record := range leases {
+		if record.expireAt > timestamp_ms {
+			alive = append(alive, id)
+		}
+	}
+	sort.Slice(alive, func(i, j int) bool {
+		a, b := leases[alive[i]], leases[alive[j]]
+		if a.expireAt != b.expireAt {
+			return a.expireAt < b.expireAt
+		}
+		return alive[i] < alive[j]
+	})
+	return alive, nil
+}
+
+func (s *MemoryLeaseStore) ListContext(ctx context.Context, workspace string, opts ListOptions) (*LeasePage, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if len(workspace) == 0 {
+		return nil, fmt.Errorf("INVALID_ARGUMENT")
+	}
+
+	cursor, err := parseListCursor(opts.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Limit <= 0 {
+		opts.Limit = DEFAULT_LIST_LIMIT
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	leases := s.workspace(workspace)
+
+	var ids []string
+	for id, record := range leases {
+		switch {
+		case opts.MinExpireAt > 0 && record.expireAt < int64(opts.MinExpireAt):
+			continue
+		case opts.MaxExpireAt > 0 && record.expireAt > int64(opts.MaxExpireAt):
+			continue
+		case !strings.HasPrefix(id, opts.Prefix):
+			continue
+		case cursor != nil && (record.expireAt < cursor.expireAt ||
+			record.expireAt == cursor.expireAt && id <= cursor.lease):
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		a, b := leases[ids[i]], leases[ids[j]]
+		if a.expireAt != b.expireAt {
+			return a.expireAt < b.expireAt
+		}
+		return ids[i] < ids[j]
+	})
+
+	page := &LeasePage{}
+	if int64(len(ids)) > opts.Limit {
+		ids = ids[:opts.Limit]
+		last := ids[len(ids)-1]
+		page.Cursor = listCursor{expireAt: leases[last].expireAt, lease: last}.String()
+	}
+	page.Leases = make([]*Lease, len(ids))
+	for i, id := range ids {
+		page.Leases[i] = leases[id].toLease(id, opts.WithDetails)
+	}
+	return page, nil
+}
+
+// Messages returns a snapshot of the entries appended to the specified sink.
+func (s *MemoryLeaseStore) Messages(sink string) []redis.XMessage {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	messages := s.sinks[sink]
+	result := make([]redis.XMessage, len(messages))
+	copy(result, messages)
+	return result
+}
+
+// DrainMessages returns and removes the entries appended to the specified
+// sink.
+func (s *MemoryLeaseStore) DrainMessages(sink string) []redis.XMessage {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	messages := s.sinks[sink]
+	delete(s.sinks, sink)
+	return messages
+}
+
+func (s *MemoryLeaseStore) validate(ctx context.Context, workspace, lease string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if len(workspace) == 0 || len(lease) == 0 {
+		return fmt.Errorf("INVALID_ARGUMENT")
+	}
+	return nil
+}
+
+func (s *MemoryLeaseStore) workspace(name string) map[string]*memoryLease {
+	if s.workspaces == nil {
+		s.workspaces = make(map[string]map[string]*memoryLease)
+	}
+	leases, ok := s.workspaces[name]
+	if !ok {
+		leases = make(map[string]*memoryLease)
+		s.workspaces[name] = leases
+	}
+	return leases
+}
+
+func (s *MemoryLeaseStore) appendEvent(sink, action, workspace, lease string, record *memoryLease) {
+	if s.sinks == nil {
+		s.sinks = make(map[string][]redis.XMessage)
+	}
+
+	values := map[string]interface{}{
+		"action":    action,
+		"workspace": workspace,
+		"lease":     lease,
+		"expire_at": strconv.FormatInt(record.expireAt, 10),
+		"revision":  strconv.FormatInt(record.revision, 10),
+	}
+	if len(record.metadata) > 0 {
+		values["metadata"] = record.metadata
+	}
+	if len(record.traceparent) > 0 {
+		values["traceparent"] = record.traceparent
+	}
+
+	s.sinks[sink] = append(s.sinks[sink], redis.XMessage{
+		ID:     s.nextEventID(),
+		Values: values,
+	})
+}
+
+func (s *MemoryLeaseStore) nextEventID() string {
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	if now > s.lastEventTime {
+		s.lastEventTime = now
+		s.lastEventSeq = 0
+	} else {
+		s.lastEventSeq++
+	}
+	return fmt.Sprintf("%d-%d", s.lastEventTime, s.lastEventSeq)
+}
@@ -0,0 +1,435 @@
+package internal
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMemoryLeaseStore_Put(t *testing.T) {
+	s := NewMemoryLeaseStore()
+	ctx := context.Background()
+	{
+		revision, err := s.PutWithRevisionContext(ctx, "op/lease", "lease-1", 300*time.Millisecond, time.Date(2021, 9, 8, 16, 3, 4, 0, time.UTC))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var expectedRevision int64 = 1
+		if revision != expectedRevision {
+			t.Errorf("expect %v, but got %v", expectedRevision, revision)
+		}
+	}
+
+	// duplicated operation
+	{
+		revision, err := s.PutWithRevisionContext(ctx, "op/lease", "lease-1", 300*time.Millisecond, time.Date(2021, 9, 8, 16, 3, 4, 0, time.UTC))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var expectedRevision int64 = 0
+		if revision != expectedRevision {
+			t.Errorf("expect %v, but got %v", expectedRevision, revision)
+		}
+	}
+
+	// later operation
+	{
+		revision, err := s.PutWithRevisionContext(ctx, "op/lease", "lease-1", 300*time.Millisecond, time.Date(2021, 9, 8, 16, 3, 5, 0, time.UTC))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var expectedRevision int64 = 2
+		if revision != expectedRevision {
+			t.Errorf("expect %v, but got %v", expectedRevision, revision)
+		}
+	}
+}
+
+func TestMemoryLeaseStore_Get(t *testing.T) {
+	s := NewMemoryLeaseStore()
+	ctx := context.Background()
+
+	_, err := s.PutContext(ctx, "op/lease", "lease-1", 300*time.Millisecond, time.Date(2021, 9, 8, 16, 3, 4, 0, time.UTC),
+		MetadataArg(map[string]string{"owner": "user-42"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lease, err := s.GetContext(ctx, "op/lease", "lease-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lease == nil {
+		t.Fatal("Lease should not be nil")
+	}
+
+	var expectedTTL time.Duration = 300 * time.Millisecond
+	if lease.TTL != expectedTTL {
+		t.Errorf("Lease.TTL: expect %v, but got %v", expectedTTL, lease.TTL)
+	}
+	var expectedTimestamp Timestamp = 1631116984000
+	if lease.Timestamp != expectedTimestamp {
+		t.Errorf("Lease.Timestamp: expect %v, but got %v", expectedTimestamp, lease.Timestamp)
+	}
+	var expectedExpireAt Timestamp = 1631116984300
+	if lease.ExpireAt == nil || *lease.ExpireAt != expectedExpireAt {
+		t.Errorf("Lease.ExpireAt: expect %v, but got %v", expectedExpireAt, lease.ExpireAt)
+	}
+	var expectedOwner string = "user-42"
+	if lease.Metadata["owner"] != expectedOwner {
+		t.Errorf("Lease.Metadata[owner]: expect %v, but got %v", expectedOwner, lease.Metadata["owner"])
+	}
+}
+
+func TestMemoryLeaseStore_Renew(t *testing.T) {
+	s := NewMemoryLeaseStore()
+	ctx := context.Background()
+
+	_, err := s.PutContext(ctx, "op/lease", "lease-1", 300*time.Millisecond, time.Date(2021, 9, 8, 16, 3, 4, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		expireAt, err := s.RenewContext(ctx, "op/lease", "lease-1", time.Date(2021, 9, 8, 16, 3, 4, int(150*time.Millisecond), time.UTC))
+		// the second renewal is stale
+		if i == 0 && err != nil || i == 1 && err != ErrStaleRenewal {
+			t.Errorf("unexpected error %v", err)
+		}
+		var expectedExpireAt Timestamp = 1631116984450
+		if expireAt != expectedExpireAt {
+			t.Errorf("expect %v, but got %v", expectedExpireAt, expireAt)
+		}
+	}
+}
+
+func TestMemoryLeaseStore_Expire_WithLimit(t *testing.T) {
+	s := NewMemoryLeaseStore()
+	ctx := context.Background()
+
+	for _, id := range []string{"lease-2", "lease-1"} {
+		_, err := s.PutContext(ctx, "op/lease", id, 300*time.Millisecond, time.Date(2021, 9, 8, 16, 3, 4, 0, time.UTC))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var optArgs = []*LeaseArg{
+		{
+			Name:  "LIMIT",
+			Value: 1,
+		},
+	}
+	expired, err := s.ExpireContext(ctx, "op/lease", "op/lease/events",
+		time.Date(2021, 9, 8, 16, 3, 4, int(300*time.Millisecond), time.UTC),
+		optArgs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var expectedExpired int64 = 1
+	if expired != expectedExpired {
+		t.Errorf("expect %v, but got %v", expectedExpired, expired)
+	}
+
+	messages := s.Messages("op/lease/events")
+	if len(messages) != 1 {
+		t.Fatalf("expect %v events, but got %v", 1, len(messages))
+	}
+	var expectedLease string = "lease-1"
+	if messages[0].Values["lease"] != expectedLease {
+		t.Errorf("expect %v, but got %v", expectedLease, messages[0].Values["lease"])
+	}
+	var expectedAction string = "EXPIRED"
+	if messages[0].Values["action"] != expectedAction {
+		t.Errorf("expect %v, but got %v", expectedAction, messages[0].Values["action"])
+	}
+}
+
+func TestMemoryLeaseStore_ExpireAndTrim_MaxLen(t *testing.T) {
+	s := NewMemoryLeaseStore()
+	ctx := context.Background()
+
+	for _, id := range []string{"lease-1", "lease-2", "lease-3"} {
+		_, err := s.PutContext(ctx, "op/lease", id, 300*time.Millisecond, time.Date(2021, 9, 8, 16, 3, 4, 0, time.UTC))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var optArgs = []*LeaseArg{
+		{
+			Name:  LEASE_ARG_MAXLEN,
+			Value: 2,
+		},
+	}
+	expired, trim, err := s.ExpireAndTrimContext(ctx, "op/lease", "op/lease/events",
+		time.Date(2021, 9, 8, 16, 3, 4, int(300*time.Millisecond), time.UTC),
+		optArgs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expired != 3 {
+		t.Errorf("expect %v, but got %v", 3, expired)
+	}
+	if trim.Trimmed != 1 {
+		t.Errorf("expect %v, but got %v", 1, trim.Trimmed)
+	}
+
+	messages := s.Messages("op/lease/events")
+	if len(messages) != 2 {
+		t.Fatalf("expect %v events, but got %v", 2, len(messages))
+	}
+	var expectedLease string = "lease-2"
+	if messages[0].Values["lease"] != expectedLease {
+		t.Errorf("expect %v, but got %v", expectedLease, messages[0].Values["lease"])
+	}
+}
+
+func TestMemoryLeaseStore_Owner(t *testing.T) {
+	s := NewMemoryLeaseStore()
+	ctx := context.Background()
+
+	var (
+		nx    = &LeaseArg{Name: LEASE_ARG_NX, Value: 1}
+		owner = &LeaseArg{Name: LEASE_ARG_OWNER, Value: "token-1"}
+		other = &LeaseArg{Name: LEASE_ARG_OWNER, Value: "token-2"}
+	)
+
+	revision, err := s.PutWithRevisionContext(ctx, "op/lock", "lock-1", 300*time.Millisecond, time.Date(2021, 9, 8, 16, 3, 4, 0, time.UTC), nx, owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revision != 1 {
+		t.Errorf("expect %v, but got %v", 1, revision)
+	}
+
+	// the lease is present
+	revision, err = s.PutWithRevisionContext(ctx, "op/lock", "lock-1", 300*time.Millisecond, time.Date(2021, 9, 8, 16, 3, 4, int(100*time.Millisecond), time.UTC), nx, other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revision != 0 {
+		t.Errorf("expect %v, but got %v", 0, revision)
+	}
+
+	expireAt, err := s.RenewContext(ctx, "op/lock", "lock-1", time.Date(2021, 9, 8, 16, 3, 5, 0, time.UTC), other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expireAt != 0 {
+		t.Errorf("expect %v, but got %v", 0, expireAt)
+	}
+
+	// without an owner
+	expireAt, err = s.RenewContext(ctx, "op/lock", "lock-1", time.Date(2021, 9, 8, 16, 3, 5, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expireAt != 0 {
+		t.Errorf("expect %v, but got %v", 0, expireAt)
+	}
+
+	ok, err := s.DeleteContext(ctx, "op/lock", "lock-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Errorf("expect %v, but got %v", false, ok)
+	}
+
+	ok, err = s.DeleteContext(ctx, "op/lock", "lock-1", other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Errorf("expect %v, but got %v", false, ok)
+	}
+
+	ok, err = s.DeleteContext(ctx, "op/lock", "lock-1", owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Errorf("expect %v, but got %v", true, ok)
+	}
+}
+
+func TestMemoryLeaseStore_Put_NXOverExpired(t *testing.T) {
+	s := NewMemoryLeaseStore()
+	ctx := context.Background()
+
+	var (
+		nx    = &LeaseArg{Name: LEASE_ARG_NX, Value: 1}
+		owner = &LeaseArg{Name: LEASE_ARG_OWNER, Value: "token-1"}
+		other = &LeaseArg{Name: LEASE_ARG_OWNER, Value: "token-2"}
+	)
+
+	_, err := s.PutContext(ctx, "op/lock", "lock-1", 300*time.Millisecond, time.Date(2021, 9, 8, 16, 3, 4, 0, time.UTC), nx, owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the lease is expired but not reaped yet
+	revision, err := s.PutWithRevisionContext(ctx, "op/lock", "lock-1", 300*time.Millisecond, time.Date(2021, 9, 8, 16, 3, 5, 0, time.UTC), nx, other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revision != 2 {
+		t.Errorf("expect %v, but got %v", 2, revision)
+	}
+
+	expireAt, err := s.RenewContext(ctx, "op/lock", "lock-1", time.Date(2021, 9, 8, 16, 3, 5, 0, time.UTC), owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expireAt != 0 {
+		t.Errorf("expect %v, but got %v", 0, expireAt)
+	}
+}
+
+func TestMemoryLeaseStore_ListAlive(t *testing.T) {
+	s := NewMemoryLeaseStore()
+	ctx := context.Background()
+
+	for id, ttl := range map[string]time.Duration{
+		"lease-1": 400 * time.Millisecond,
+		"lease-2": 100 * time.Millisecond,
+		"lease-3": 300 * time.Millisecond,
+	} {
+		_, err := s.PutContext(ctx, "op/lease", id, ttl, time.Date(2021, 9, 8, 16, 3, 4, 0, time.UTC))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ids, err := s.ListAliveContext(ctx, "op/lease", time.Date(2021, 9, 8, 16, 3, 4, int(200*time.Millisecond), time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var expectedIDs = []string{"lease-3", "lease-1"}
+	if !reflect.DeepEqual(ids, expectedIDs) {
+		t.Errorf("expect %v, but got %v", expectedIDs, ids)
+	}
+}
+
+func TestMemoryLeaseStore_List(t *testing.T) {
+	s := NewMemoryLeaseStore()
+	ctx := context.Background()
+
+	for id, ttl := range map[string]time.Duration{
+		"lease-1": 400 * time.Millisecond,
+		"lease-2": 100 * time.Millisecond,
+		"lease-3": 300 * time.Millisecond,
+		"lock-1":  200 * time.Millisecond,
+	} {
+		_, err := s.PutContext(ctx, "op/lease", id, ttl, time.Date(2021, 9, 8, 16, 3, 4, 0, time.UTC))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var (
+		ids  []string
+		opts = ListOptions{
+			Prefix: "lease-",
+			Limit:  2,
+		}
+	)
+	for {
+		page, err := s.ListContext(ctx, "op/lease", opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, lease := range page.Leases {
+			ids = append(ids, lease.ID)
+		}
+		if len(page.Cursor) == 0 {
+			break
+		}
+		opts.Cursor = page.Cursor
+	}
+	var expectedIDs = []string{"lease-2", "lease-3", "lease-1"}
+	if !reflect.DeepEqual(ids, expectedIDs) {
+		t.Errorf("expect %v, but got %v", expectedIDs, ids)
+	}
+
+	page, err := s.ListContext(ctx, "op/lease", ListOptions{
+		MinExpireAt: Timestamp(time.Date(2021, 9, 8, 16, 3, 4, int(200*time.Millisecond), time.UTC).UnixNano() / int64(time.Millisecond)),
+		MaxExpireAt: Timestamp(time.Date(2021, 9, 8, 16, 3, 4, int(300*time.Millisecond), time.UTC).UnixNano() / int64(time.Millisecond)),
+		WithDetails: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Leases) != 2 {
+		t.Fatalf("expect %v leases, but got %v", 2, len(page.Leases))
+	}
+	var expectedTTL = 200 * time.Millisecond
+	if page.Leases[0].ID != "lock-1" || page.Leases[0].TTL != expectedTTL {
+		t.Errorf("expect %v with ttl %v, but got %v with ttl %v", "lock-1", expectedTTL, page.Leases[0].ID, page.Leases[0].TTL)
+	}
+}
+
+func TestMemoryLeaseStore_List_LastPage(t *testing.T) {
+	testListLastPage(t, NewMemoryLeaseStore(), "op/lease")
+}
+
+// testListLastPage checks that the stores return a Cursor only when more
+// leases remain to be listed.
+func testListLastPage(t *testing.T, store interface {
+	LeaseStore
+	LeasePager
+}, workspace string) {
+	t.Helper()
+
+	ctx := context.Background()
+	timestamp := time.Date(2021, 9, 8, 16, 3, 4, 0, time.UTC)
+	for id, ttl := range map[string]time.Duration{
+		"lease-1": 100 * time.Millisecond,
+		"lease-2": 200 * time.Millisecond,
+		"lease-3": 300 * time.Millisecond,
+		"lease-4": 400 * time.Millisecond,
+		"lock-1":  500 * time.Millisecond,
+	} {
+		_, err := store.PutContext(ctx, workspace, id, ttl, timestamp)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		name  string
+		opts  ListOptions
+		pages []int
+	}{
+		{"all leases", ListOptions{Limit: 5}, []int{5}},
+		{"one lease left", ListOptions{Limit: 4}, []int{4, 1}},
+		{"no match left", ListOptions{Prefix: "lease-", Limit: 4}, []int{4}},
+		{"matches left", ListOptions{Prefix: "lease-", Limit: 2}, []int{2, 2}},
+		{"out of range", ListOptions{
+			MaxExpireAt: Timestamp(timestamp.Add(300*time.Millisecond).UnixNano() / int64(time.Millisecond)),
+			Limit:       3,
+		}, []int{3}},
+	}
+	for _, c := range cases {
+		var (
+			pages []int
+			opts  = c.opts
+		)
+		for len(pages) <= len(c.pages) {
+			page, err := store.ListContext(ctx, workspace, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pages = append(pages, len(page.Leases))
+			if len(page.Cursor) == 0 {
+				break
+			}
+			opts.Cursor = page.Cursor
+		}
+		if !reflect.DeepEqual(pages, c.pages) {
+			t.Errorf("%s: expect pages of %v leases, but got %v", c.name, c.pages, pages)
+		}
+	}
+}
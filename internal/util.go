@@ -4,3 +4,22 @@ func redisArgs(args ...interface{}) RedisArgsBuilder {
 	var arg RedisArgsBuilder
 	return arg.Pack(args...)
 }
+
+func revisionsToOK(revisions []int64) []bool {
+	results := make([]bool, len(revisions))
+	for i, revision := range revisions {
+		results[i] = revision > 0
+	}
+	return results
+}
+
+func stringsOf(reply interface{}) []string {
+	values, _ := reply.([]interface{})
+	var strs []string
+	for _, v := range values {
+		if str, ok := v.(string); ok {
+			strs = append(strs, str)
+		}
+	}
+	return strs
+}
@@ -1,6 +1,9 @@
 package lease
 
 import (
+	"time"
+
+	"github.com/bcowtech/lib-redis-lease/internal"
 	"github.com/bcowtech/lib-redis-lease/internal/helper"
 )
 
@@ -11,6 +14,51 @@ func WithLimit(limit int) *LeaseArg {
 	}
 }
 
+// WithSinkMaxLen trims the event sink to about maxlen entries on expiry.
+func WithSinkMaxLen(maxlen int64) *LeaseArg {
+	return &LeaseArg{
+		Name:  internal.LEASE_ARG_MAXLEN,
+		Value: maxlen,
+	}
+}
+
+// WithSinkMaxAge trims the entries older than maxAge from the event sink on
+// expiry.
+func WithSinkMaxAge(maxAge time.Duration) *LeaseArg {
+	return &LeaseArg{
+		Name:  internal.LEASE_ARG_MINID_AGE,
+		Value: maxAge.Milliseconds(),
+	}
+}
+
+func WithMetadata(metadata map[string]string) *LeaseArg {
+	return internal.MetadataArg(metadata)
+}
+
+// WithTraceParent stores the W3C traceparent with the lease, and copies it
+// into the lifecycle events of the lease.
+func WithTraceParent(traceparent string) *LeaseArg {
+	var value interface{}
+	if len(traceparent) > 0 {
+		value = traceparent
+	}
+	return &LeaseArg{
+		Name:  internal.LEASE_ARG_TRACEPARENT,
+		Value: value,
+	}
+}
+
 func CreateRedisUniversalClient(opt *RedisOption) (RedisClient, error) {
 	return helper.CreateRedisUniversalClient(opt)
 }
+
+func CreateRedisClusterClient(opt *RedisOption) (RedisClient, error) {
+	return helper.CreateRedisClusterClient(opt)
+}
+
+func createRedisClient(opt *RedisOption, clusterMode bool) (RedisClient, error) {
+	if clusterMode {
+		return CreateRedisClusterClient(opt)
+	}
+	return CreateRedisUniversalClient(opt)
+}
@@ -1,6 +1,7 @@
 package lease
 
 import (
+	"context"
 	"time"
 
 	"github.com/bcowtech/lib-redis-lease/internal"
@@ -11,19 +12,63 @@ type LeaseExpireExecutor struct {
 	eventSink string
 	options   []*LeaseArg
 
-	provider *internal.LeaseProvider
+	store LeaseStore
+
+	pollingTimeout time.Duration
+	idlingTimeout  time.Duration
+	priority       int
+	trimming       bool
+
+	// scheduling state of the LeaseReaper
+	nextAt time.Time
+	count  int64
+
+	// retrying is set from the first retriable failure until the next
+	// success, across the ticks of the LeaseReaper.
+	retrying bool
+	// err is the failure of the last execution, and failures the number of
+	// consecutive failures restarted by the LeaseReaper.
+	err      error
+	failures int
 }
 
 func (e *LeaseExpireExecutor) Execute(timestamp time.Time) (count int64, err error) {
+	return e.ExecuteContext(context.Background(), timestamp)
+}
+
+func (e *LeaseExpireExecutor) ExecuteContext(ctx context.Context, timestamp time.Time) (count int64, err error) {
+	count, _, err = e.executeContext(ctx, timestamp)
+	return count, err
+}
+
+func (e *LeaseExpireExecutor) executeContext(ctx context.Context, timestamp time.Time) (count int64, trim SinkTrimResult, err error) {
 	var (
 		workspace = e.workspace
 		sink      = e.eventSink
 		options   = e.options
 	)
 
-	expired, err := e.provider.Expire(workspace, sink, timestamp, options...)
+	if trimmer, ok := e.store.(internal.LeaseSinkTrimmer); ok && e.trimming {
+		expired, trim, err := trimmer.ExpireAndTrimContext(ctx, workspace, sink, timestamp, options...)
+		if err != nil {
+			return 0, trim, err
+		}
+		return expired, trim, nil
+	}
+
+	expired, err := e.store.ExpireContext(ctx, workspace, sink, timestamp, options...)
 	if err != nil {
-		return 0, err
+		return 0, trim, err
+	}
+	return expired, trim, nil
+}
+
+// reschedule sets the next execution time from the result of the last one:
+// soon if leases were expired, since more may follow, otherwise after idling.
+func (e *LeaseExpireExecutor) reschedule(now time.Time) {
+	if e.count > 0 {
+		e.nextAt = now.Add(e.pollingTimeout)
+	} else {
+		e.nextAt = now.Add(e.idlingTimeout)
 	}
-	return expired, nil
 }
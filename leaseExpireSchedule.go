@@ -0,0 +1,63 @@
+package lease
+
+import (
+	"container/heap"
+	"sort"
+	"time"
+)
+
+var _ heap.Interface = new(leaseExpireSchedule)
+
+// leaseExpireSchedule is a min-heap of LeaseExpireExecutor ordered by the
+// next execution time, then by the priority of the contract.
+type leaseExpireSchedule []*LeaseExpireExecutor
+
+func (s leaseExpireSchedule) Len() int { return len(s) }
+
+func (s leaseExpireSchedule) Less(i, j int) bool {
+	if !s[i].nextAt.Equal(s[j].nextAt) {
+		return s[i].nextAt.Before(s[j].nextAt)
+	}
+	return s[i].priority > s[j].priority
+}
+
+func (s leaseExpireSchedule) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+
+func (s *leaseExpireSchedule) Push(x interface{}) {
+	*s = append(*s, x.(*LeaseExpireExecutor))
+}
+
+func (s *leaseExpireSchedule) Pop() interface{} {
+	var (
+		old = *s
+		n   = len(old)
+		x   = old[n-1]
+	)
+	old[n-1] = nil
+	*s = old[:n-1]
+	return x
+}
+
+// wait returns the duration until the earliest execution, or fallback if
+// nothing is scheduled.
+func (s *leaseExpireSchedule) wait(now time.Time, fallback time.Duration) time.Duration {
+	if len(*s) == 0 {
+		return fallback
+	}
+	if d := (*s)[0].nextAt.Sub(now); d > 0 {
+		return d
+	}
+	return 0
+}
+
+// popDue removes the executors due at now, ordered by priority.
+func (s *leaseExpireSchedule) popDue(now time.Time) []*LeaseExpireExecutor {
+	var due []*LeaseExpireExecutor
+	for len(*s) > 0 && !(*s)[0].nextAt.After(now) {
+		due = append(due, heap.Pop(s).(*LeaseExpireExecutor))
+	}
+	sort.SliceStable(due, func(i, j int) bool {
+		return due[i].priority > due[j].priority
+	})
+	return due
+}
@@ -1,22 +1,39 @@
 package lease
 
-import "github.com/bcowtech/lib-redis-lease/internal"
+import (
+	"time"
+
+	"github.com/bcowtech/lib-redis-lease/internal"
+)
 
 type LeaseExpiryContract struct {
 	Workspace   string
 	EventSink   string
 	MaxInFlight int
+
+	// PollingTimeout and IdlingTimeout override the ones of the LeaseReaper
+	// for the contract. Contracts due at the same time run in the order of
+	// Priority, highest first.
+	PollingTimeout time.Duration
+	IdlingTimeout  time.Duration
+	Priority       int
+
+	// Retention of the EventSink, applied atomically on each expiry. The
+	// sink is trimmed to about SinkMaxLen entries, and the entries older
+	// than SinkMaxAge are trimmed (Redis 6.2 or later). Zero disables each.
+	SinkMaxLen int64
+	SinkMaxAge time.Duration
 }
 
-func (c *LeaseExpiryContract) createExpireExecutor(provider *internal.LeaseProvider) *LeaseExpireExecutor {
+func (c *LeaseExpiryContract) createExpireExecutor(store LeaseStore) *LeaseExpireExecutor {
 	if len(c.Workspace) == 0 {
 		logger.Panic("'Workspace' cannot be an empty string")
 	}
 	if len(c.EventSink) == 0 {
 		logger.Panic("'EventSink' cannot be an empty string")
 	}
-	if provider == nil {
-		logger.Panic("specified argument 'provider' cannot be nil")
+	if store == nil {
+		logger.Panic("specified argument 'store' cannot be nil")
 	}
 
 	var (
@@ -25,11 +42,29 @@ func (c *LeaseExpiryContract) createExpireExecutor(provider *internal.LeaseProvi
 	if c.MaxInFlight > 0 {
 		options = append(options, WithLimit(c.MaxInFlight))
 	}
+	if c.SinkMaxLen > 0 {
+		options = append(options, WithSinkMaxLen(c.SinkMaxLen))
+	}
+	if c.SinkMaxAge > 0 {
+		options = append(options, WithSinkMaxAge(c.SinkMaxAge))
+	}
 
 	return &LeaseExpireExecutor{
-		workspace: c.Workspace,
-		eventSink: c.EventSink,
-		options:   options,
-		provider:  provider,
+		workspace:      c.Workspace,
+		eventSink:      c.EventSink,
+		options:        options,
+		store:          store,
+		pollingTimeout: c.PollingTimeout,
+		idlingTimeout:  c.IdlingTimeout,
+		priority:       c.Priority,
+		trimming:       c.hasRetention(),
 	}
 }
+
+func (c *LeaseExpiryContract) hasRetention() bool {
+	return c.SinkMaxLen > 0 || c.SinkMaxAge > 0
+}
+
+func (c *LeaseExpiryContract) validateClusterKeys(keyspace *internal.KeySpace) error {
+	return keyspace.ValidateClusterKeys(c.Workspace, c.EventSink)
+}
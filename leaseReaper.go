@@ -1,21 +1,62 @@
 package lease
 
 import (
+	"container/heap"
 	"context"
 	"fmt"
 	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/bcowtech/lib-redis-lease/internal"
 	"github.com/bcowtech/lib-redis-lease/internal/helper"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type LeaseReaper struct {
 	PollingTimeout time.Duration
 	IdlingTimeout  time.Duration
 	RedisOption    *RedisOption
+	KeyPrefix      string
+	ClusterMode    bool
+	Store          LeaseStore
 	ErrorHandler   ErrorHandleProc
+	TracerProvider trace.TracerProvider
+	Logger         Logger
+
+	// What to do when a contract fails and the ErrorHandler does not handle
+	// the error. Default is FAILURE_POLICY_STOP, which stops the LeaseReaper
+	// and reports the error through Err and Done. FAILURE_POLICY_RESTART
+	// retries the contract after a backoff growing from RestartMinBackoff to
+	// RestartMaxBackoff; FAILURE_POLICY_SKIP stops sweeping the contract.
+	FailurePolicy     FailurePolicy
+	RestartMinBackoff time.Duration
+	RestartMaxBackoff time.Duration
+
+	// Maximum number of contracts executed in parallel on each tick.
+	// Default is 1. The hooks must be safe for concurrent use if it is
+	// greater than 1.
+	Concurrency int
+
+	// HA mode. When HAWorkspace is set, the instances sharing it elect one
+	// active sweeper through the lease HALeaseID; the others stay on standby
+	// and take over within about 1.25 * HALeaseTTL after the active one dies.
+	HAWorkspace string
+	HALeaseID   string
+	HALeaseTTL  time.Duration
+	HACandidate string
+
+	// Partitioning. When PartitionWorkspace is set, the instances sharing it
+	// register as members through leases, and each contract is swept only by
+	// the live member chosen for its workspace by rendezvous hashing. The
+	// contracts are rebalanced as members join or leave. The members delete
+	// the leases left in PartitionWorkspace by crashed members themselves if
+	// the Store supports Lessor.List; otherwise add an expiry contract for it.
+	PartitionWorkspace string
+	PartitionMemberID  string
+	PartitionMemberTTL time.Duration
 
 	// Maximum number of retries before giving up.
 	// Default is to not retry failed commands.
@@ -27,21 +68,31 @@ type LeaseReaper struct {
 	// Default is 512 milliseconds; -1 disables backoff.
 	maxRetryBackoff time.Duration
 
-	provider  *internal.LeaseProvider
-	executors []*LeaseExpireExecutor
-	hooks     []LeaseReaperHook
+	provider   *internal.LeaseProvider
+	store      LeaseStore
+	executors  []*LeaseExpireExecutor
+	hooks      []LeaseReaperHook
+	membership *reaperMembership
+	tracer     trace.Tracer
 
 	existedWorkspaces []string
 
 	ctx       context.Context
+	cancel    context.CancelFunc
 	stopChan  chan bool
 	pauseChan chan bool
+	addChan   chan *LeaseExpireExecutor
 	wg        sync.WaitGroup
 
+	done     chan struct{}
+	err      error
+	errMutex sync.Mutex
+
 	mutex       sync.Mutex
 	initialized bool
 	running     bool
 	disposed    bool
+	active      int32
 }
 
 func (r *LeaseReaper) Init() {
@@ -57,11 +108,75 @@ func (r *LeaseReaper) Init() {
 		r.pauseChan = make(chan bool, 1)
 	}
 
-	r.provider = new(internal.LeaseProvider)
+	if r.addChan == nil {
+		r.addChan = make(chan *LeaseExpireExecutor)
+	}
 
-	r.ctx = context.TODO()
+	if r.done == nil {
+		r.done = make(chan struct{})
+	}
 
-	{
+	switch r.FailurePolicy {
+	case "":
+		r.FailurePolicy = FAILURE_POLICY_STOP
+	case FAILURE_POLICY_STOP, FAILURE_POLICY_RESTART, FAILURE_POLICY_SKIP:
+	default:
+		logger.Panic(fmt.Sprintf("unknown FailurePolicy '%s'", r.FailurePolicy))
+	}
+	if r.RestartMinBackoff <= 0 {
+		r.RestartMinBackoff = DEFAULT_REAPER_RESTART_MIN_BACKOFF
+	}
+	if r.RestartMaxBackoff <= 0 {
+		r.RestartMaxBackoff = DEFAULT_REAPER_RESTART_MAX_BACKOFF
+	}
+
+	if r.Store != nil {
+		r.store = r.Store
+	} else {
+		r.provider = &internal.LeaseProvider{
+			KeyPrefix: r.KeyPrefix,
+		}
+		r.store = r.provider
+	}
+
+	r.ctx, r.cancel = context.WithCancel(context.Background())
+	r.tracer = createTracer(r.TracerProvider)
+
+	if len(r.HAWorkspace) > 0 {
+		if len(r.HALeaseID) == 0 {
+			r.HALeaseID = DEFAULT_REAPER_HA_LEASE_ID
+		}
+		if r.HALeaseTTL <= 0 {
+			r.HALeaseTTL = DEFAULT_REAPER_HA_LEASE_TTL
+		}
+		if len(r.HACandidate) == 0 {
+			token, err := helper.RandomToken()
+			if err != nil {
+				logger.Panic(err)
+			}
+			r.HACandidate = token
+		}
+	} else {
+		r.active = 1
+	}
+
+	if len(r.PartitionWorkspace) > 0 {
+		if len(r.HAWorkspace) > 0 {
+			logger.Panic("'HAWorkspace' and 'PartitionWorkspace' cannot be both specified")
+		}
+		if r.PartitionMemberTTL <= 0 {
+			r.PartitionMemberTTL = DEFAULT_REAPER_PARTITION_MEMBER_TTL
+		}
+		if len(r.PartitionMemberID) == 0 {
+			token, err := helper.RandomToken()
+			if err != nil {
+				logger.Panic(err)
+			}
+			r.PartitionMemberID = token
+		}
+	}
+
+	if r.RedisOption != nil {
 		r.maxRetries = r.RedisOption.MaxRetries
 		r.maxRetryBackoff = r.RedisOption.MaxRetryBackoff
 		r.minRetryBackoff = r.RedisOption.MinRetryBackoff
@@ -85,29 +200,69 @@ func (r *LeaseReaper) Init() {
 		r.RedisOption.MaxRetries = 0
 		r.RedisOption.MaxRetryBackoff = -1
 		r.RedisOption.MinRetryBackoff = -1
+	} else {
+		r.minRetryBackoff = 8 * time.Millisecond
+		r.maxRetryBackoff = 512 * time.Millisecond
 	}
 
 	r.initialized = true
 	return
 }
 
+// AddExpiryContracts adds the contracts to sweep. The contracts added while
+// the LeaseReaper is running are scheduled right away.
 func (r *LeaseReaper) AddExpiryContracts(contracts ...*LeaseExpiryContract) error {
 	if !r.initialized {
 		logger.Panic("the LeaseReaper haven't be initialized yet")
 	}
 
+	added, running, err := r.registerExpiryContracts(contracts...)
+	// the executors are handed to the running LeaseReaper outside the lock,
+	// which is never held while waiting on the sweeping goroutine
+	if running {
+		for _, executor := range added {
+			select {
+			case r.addChan <- executor:
+			case <-r.done:
+			}
+		}
+	}
+	return err
+}
+
+func (r *LeaseReaper) registerExpiryContracts(contracts ...*LeaseExpiryContract) (added []*LeaseExpireExecutor, running bool, err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
 	for _, contract := range contracts {
+		if r.ClusterMode {
+			if err := contract.validateClusterKeys(internal.NewKeySpace(r.KeyPrefix)); err != nil {
+				return added, r.running, err
+			}
+		}
+		if _, ok := r.store.(internal.LeaseSinkTrimmer); contract.hasRetention() && !ok {
+			return added, r.running, fmt.Errorf("the Store does not support the retention of workspace '%s'", contract.Workspace)
+		}
 		if found := r.isDuplicatedWorkspace(contract.Workspace); found {
-			return fmt.Errorf("specified workspace '%s' is duplicated", contract.Workspace)
+			return added, r.running, fmt.Errorf("specified workspace '%s' is duplicated", contract.Workspace)
 		}
-		r.executors = append(r.executors, contract.createExpireExecutor(r.provider))
+		executor := contract.createExpireExecutor(r.store)
+		r.executors = append(r.executors, executor)
+		added = append(added, executor)
 	}
-
-	return nil
+	return added, r.running, nil
 }
 
+// AddHook adds a hook of the LeaseReaper. The optional hooks it implements,
+// such as LeaseReaperCompletionHook, are called too. If the hook also
+// implements LeaseScriptHook and the LeaseReaper uses the Redis store, it is
+// called after each script execution too.
 func (r *LeaseReaper) AddHook(hook LeaseReaperHook) {
 	r.hooks = append(r.hooks, hook)
+
+	if h, ok := hook.(LeaseScriptHook); ok && r.provider != nil {
+		r.provider.AddHook(h)
+	}
 }
 
 func (r *LeaseReaper) Start() error {
@@ -127,6 +282,8 @@ func (r *LeaseReaper) Start() error {
 		if err != nil {
 			r.running = false
 			r.disposed = true
+			r.setErr(err)
+			close(r.done)
 		}
 		r.mutex.Unlock()
 	}()
@@ -139,8 +296,9 @@ func (r *LeaseReaper) Start() error {
 	)
 
 	// redisClient
-	{
-		client, err := CreateRedisUniversalClient(r.RedisOption)
+	if r.provider != nil {
+		var client RedisClient
+		client, err = createRedisClient(r.RedisOption, r.ClusterMode)
 		if err != nil {
 			return err
 		}
@@ -148,16 +306,112 @@ func (r *LeaseReaper) Start() error {
 		redisClient = client
 	}
 
-	timer := time.NewTimer(pollingTimeout)
+	// HA mode and partitioning
+	var coordinators sync.WaitGroup
+	if len(r.HAWorkspace) > 0 || len(r.PartitionWorkspace) > 0 {
+		lessor := &Lessor{
+			KeyPrefix:   r.KeyPrefix,
+			ClusterMode: r.ClusterMode,
+			Store:       r.store,
+		}
+		err = lessor.Init()
+		if err == nil && len(r.HAWorkspace) > 0 {
+			err = lessor.validateWorkspace(r.HAWorkspace)
+		}
+		if err == nil && len(r.PartitionWorkspace) > 0 {
+			err = lessor.validateWorkspace(r.PartitionWorkspace)
+			if _, ok := r.store.(internal.LeaseLister); err == nil && !ok {
+				err = fmt.Errorf("the Store does not support listing leases")
+			}
+		}
+		if err != nil {
+			if redisClient != nil {
+				redisClient.Close()
+			}
+			return err
+		}
+
+		if len(r.HAWorkspace) > 0 {
+			election := &Election{
+				Lessor:        lessor,
+				Workspace:     r.HAWorkspace,
+				Name:          r.HALeaseID,
+				TTL:           r.HALeaseTTL,
+				RetryInterval: r.HALeaseTTL / 4,
+				ErrorHandler: func(err error) {
+					r.processRedisError(err)
+				},
+			}
+
+			coordinators.Add(1)
+			go func() {
+				defer coordinators.Done()
+				r.campaign(election)
+			}()
+		}
+
+		if len(r.PartitionWorkspace) > 0 {
+			r.membership = &reaperMembership{
+				lessor:    lessor,
+				lister:    r.store.(internal.LeaseLister),
+				workspace: r.PartitionWorkspace,
+				memberID:  r.PartitionMemberID,
+				ttl:       r.PartitionMemberTTL,
+				errorHandler: func(err error) {
+					r.processRedisError(err)
+				},
+			}
+
+			coordinators.Add(1)
+			go func() {
+				defer coordinators.Done()
+				r.membership.run(r.ctx)
+			}()
+		}
+	}
+
+	// schedule
+	var schedule = make(leaseExpireSchedule, 0, len(r.executors))
+	enqueue := func(v *LeaseExpireExecutor, now time.Time) {
+		if v.pollingTimeout <= 0 {
+			v.pollingTimeout = pollingTimeout
+		}
+		if v.idlingTimeout <= 0 {
+			v.idlingTimeout = idlingTimeout
+		}
+		v.nextAt = now.Add(v.pollingTimeout)
+		heap.Push(&schedule, v)
+	}
+	{
+		now := time.Now()
+		for _, v := range r.executors {
+			enqueue(v, now)
+		}
+	}
+
+	timer := time.NewTimer(schedule.wait(time.Now(), pollingTimeout))
 
+	r.wg.Add(1)
 	go func() {
 		r.triggerOnStart()
-		r.wg.Add(1)
 		defer func() {
+			// the LeaseReaper cannot be started again once stopped, either by
+			// Stop or by a failure
+			r.mutex.Lock()
+			r.running = false
+			r.disposed = true
+			r.mutex.Unlock()
+
+			close(r.done)
 			r.wg.Done()
 			r.triggerOnStop()
 		}()
-		defer redisClient.Close()
+		defer func() {
+			coordinators.Wait()
+			if redisClient != nil {
+				redisClient.Close()
+			}
+		}()
 		defer func() {
 			if !timer.Stop() {
 				select {
@@ -173,30 +427,53 @@ func (r *LeaseReaper) Start() error {
 			case <-r.stopChan:
 				return
 
+			case <-r.ctx.Done():
+				return
+
 			case pause := <-r.pauseChan:
 				if running != !pause {
 					running = !pause
 					if running {
-						timer.Reset(pollingTimeout)
+						timer.Reset(schedule.wait(time.Now(), pollingTimeout))
 					}
 				}
 				break
 
+			case v := <-r.addChan:
+				now := time.Now()
+				enqueue(v, now)
+				if running {
+					if !timer.Stop() {
+						select {
+						case <-timer.C:
+						default:
+						}
+					}
+					timer.Reset(schedule.wait(now, idlingTimeout))
+				}
+
 			case next := <-timer.C:
 				if running {
-					count, err := r.removeExpiredLeases(next)
-					if err != nil {
-						if !r.processRedisError(err) {
-							logger.Fatalf("%% Error: %v\n", err)
+					due := schedule.popDue(next)
+					if r.IsActive() {
+						_, err := r.removeExpiredLeases(due, next)
+						if err != nil && r.ctx.Err() != nil {
 							return
 						}
+					} else {
+						for _, v := range due {
+							v.count = 0
+							v.err = nil
+						}
 					}
 
-					if count > 0 {
-						timer.Reset(pollingTimeout)
-					} else {
-						timer.Reset(idlingTimeout)
+					now := time.Now()
+					if err := r.superviseExecutors(&schedule, due, now); err != nil {
+						r.setErr(err)
+						r.cancel()
+						return
 					}
+					timer.Reset(schedule.wait(now, idlingTimeout))
 				}
 			}
 		}
@@ -206,37 +483,97 @@ func (r *LeaseReaper) Start() error {
 }
 
 func (r *LeaseReaper) Stop() {
+	r.mutex.Lock()
 	if r.disposed {
+		r.mutex.Unlock()
 		return
 	}
+	r.running = false
+	r.disposed = true
+	r.mutex.Unlock()
 
-	r.mutex.Lock()
-	defer func() {
-		r.running = false
-		r.disposed = true
-
-		r.mutex.Unlock()
-	}()
+	if r.cancel != nil {
+		r.cancel()
+	}
 
 	if r.stopChan != nil {
 		r.stopChan <- true
 		close(r.stopChan)
 	}
 
-	close(r.pauseChan)
-
 	r.wg.Wait()
 }
 
-func (r *LeaseReaper) Pause() {
-	if r.running {
-		r.pauseChan <- true
+// Done returns a channel which is closed when the LeaseReaper stops, either
+// by Stop or because of a failure under FAILURE_POLICY_STOP.
+func (r *LeaseReaper) Done() <-chan struct{} {
+	if !r.initialized {
+		logger.Panic("the LeaseReaper haven't be initialized yet")
 	}
+	return r.done
+}
+
+// Err returns the error which stopped the LeaseReaper, or nil if it is
+// running or was stopped by Stop.
+func (r *LeaseReaper) Err() error {
+	r.errMutex.Lock()
+	defer r.errMutex.Unlock()
+
+	return r.err
+}
+
+func (r *LeaseReaper) Pause() {
+	r.setPaused(true)
 }
 
 func (r *LeaseReaper) Resume() {
-	if r.running {
-		r.pauseChan <- false
+	r.setPaused(false)
+}
+
+// IsActive reports whether the LeaseReaper sweeps the contracts. It is
+// always true unless the LeaseReaper runs in HA mode.
+func (r *LeaseReaper) IsActive() bool {
+	return atomic.LoadInt32(&r.active) == 1
+}
+
+func (r *LeaseReaper) campaign(election *Election) {
+	for {
+		err := election.Campaign(r.ctx, r.HACandidate)
+		if err != nil {
+			if r.ctx.Err() != nil {
+				return
+			}
+			r.processRedisError(err)
+			if err := helper.Sleep(r.ctx, election.RetryInterval); err != nil {
+				return
+			}
+			continue
+		}
+
+		atomic.StoreInt32(&r.active, 1)
+		resolveLogger(r.Logger).Info("became the active reaper",
+			LOG_FIELD_WORKSPACE, r.HAWorkspace,
+			LOG_FIELD_LEASE_ID, r.HALeaseID)
+		r.triggerOnActive()
+
+		// the term also ends when the renewals fail until the lease would
+		// have expired
+		select {
+		case <-election.Done():
+			atomic.StoreInt32(&r.active, 0)
+			resolveLogger(r.Logger).Warn("lost the active reaper lease",
+				LOG_FIELD_WORKSPACE, r.HAWorkspace,
+				LOG_FIELD_LEASE_ID, r.HALeaseID)
+			r.triggerOnStandby()
+
+		case <-r.ctx.Done():
+			atomic.StoreInt32(&r.active, 0)
+			if err := election.Resign(context.Background()); err != nil {
+				r.processRedisError(err)
+			}
+			r.triggerOnStandby()
+			return
+		}
 	}
 }
 
@@ -253,10 +590,72 @@ func (r *LeaseReaper) isDuplicatedWorkspace(workspace string) bool {
 	existed = append(existed, "")
 	copy(existed[found+1:], existed[found:])
 	existed[found] = workspace
+	r.existedWorkspaces = existed
 
 	return false
 }
 
+// superviseExecutors puts the executors back to the schedule, applying the
+// FailurePolicy to those which failed. It returns the error to stop with.
+func (r *LeaseReaper) superviseExecutors(schedule *leaseExpireSchedule, executors []*LeaseExpireExecutor, now time.Time) error {
+	var stopErr error
+	for _, v := range executors {
+		err := v.err
+		v.err = nil
+		if err == nil || r.processRedisError(err) {
+			v.failures = 0
+			v.reschedule(now)
+			heap.Push(schedule, v)
+			continue
+		}
+
+		resolveLogger(r.Logger).Error("failed to remove expired leases",
+			LOG_FIELD_WORKSPACE, v.workspace,
+			LOG_FIELD_EVENT_SINK, v.eventSink,
+			"policy", r.FailurePolicy,
+			LOG_FIELD_ERROR, err)
+		r.triggerOnFailure(v.workspace, v.eventSink, err)
+
+		switch r.FailurePolicy {
+		case FAILURE_POLICY_SKIP:
+			// leave the executor out of the schedule
+
+		case FAILURE_POLICY_RESTART:
+			v.nextAt = now.Add(helper.RetryBackoff(v.failures, r.RestartMinBackoff, r.RestartMaxBackoff))
+			v.failures++
+			heap.Push(schedule, v)
+
+		default:
+			if stopErr == nil {
+				stopErr = fmt.Errorf("failed to remove the expired leases of workspace '%s': %w", v.workspace, err)
+			}
+		}
+	}
+	return stopErr
+}
+
+func (r *LeaseReaper) setPaused(pause bool) {
+	r.mutex.Lock()
+	running := r.running
+	r.mutex.Unlock()
+
+	if running {
+		select {
+		case r.pauseChan <- pause:
+		case <-r.done:
+		}
+	}
+}
+
+func (r *LeaseReaper) setErr(err error) {
+	r.errMutex.Lock()
+	defer r.errMutex.Unlock()
+
+	if r.err == nil {
+		r.err = err
+	}
+}
+
 func (r *LeaseReaper) processRedisError(err error) (disposed bool) {
 	if r.ErrorHandler != nil {
 		return r.ErrorHandler(err)
@@ -264,45 +663,114 @@ func (r *LeaseReaper) processRedisError(err error) (disposed bool) {
 	return false
 }
 
-func (r *LeaseReaper) removeExpiredLeases(expireAt time.Time) (count int64, err error) {
+func (r *LeaseReaper) removeExpiredLeases(executors []*LeaseExpireExecutor, expireAt time.Time) (count int64, err error) {
+	var (
+		workers int = r.Concurrency
+		queue       = make(chan *LeaseExpireExecutor)
+		wg      sync.WaitGroup
+		mutex   sync.Mutex
+		total   int64
+		lastErr error
+	)
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(executors) {
+		workers = len(executors)
+	}
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for v := range queue {
+				expired, err := r.removeExpiredLeasesOf(v, expireAt)
+				v.err = err
+
+				mutex.Lock()
+				total = total + expired
+				if err != nil {
+					lastErr = err
+				}
+				mutex.Unlock()
+			}
+		}()
+	}
+
+	for _, v := range executors {
+		if r.membership != nil && !r.membership.owns(v.workspace) {
+			v.count = 0
+			v.err = nil
+			continue
+		}
+		queue <- v
+	}
+	close(queue)
+	wg.Wait()
+
+	return total, lastErr
+}
+
+func (r *LeaseReaper) removeExpiredLeasesOf(v *LeaseExpireExecutor, expireAt time.Time) (count int64, err error) {
 	var (
 		total           int64         = 0
 		attempts        int           = r.maxRetries
 		maxRetryBackoff time.Duration = r.maxRetryBackoff
 		minRetryBackoff time.Duration = r.minRetryBackoff
-		retrying        bool          = false
-		lastErr         error
 	)
-	for _, v := range r.executors {
-		// reset the paused flag
-		retrying = false
-		r.triggerOnProcess(v.workspace, v.eventSink, expireAt)
-		for attempt := 0; attempt <= attempts; attempt++ {
-			expired, err := v.Execute(expireAt)
-			total = total + expired
-			if err == nil {
-				if retrying {
-					retrying = false
-					r.triggerOnRecover(v.workspace, v.eventSink)
-				}
-				break
-			}
 
-			if helper.IsRetriableError(err, true) {
-				if !retrying {
-					retrying = true
-					r.triggerOnRetry(v.workspace, v.eventSink, expireAt)
-				}
+	ctx, span := r.tracer.Start(r.ctx, "lease.Sweep",
+		trace.WithAttributes(
+			TRACE_ATTR_WORKSPACE.String(v.workspace),
+			TRACE_ATTR_EVENT_SINK.String(v.eventSink)))
 
-				if err := helper.Sleep(r.ctx, helper.RetryBackoff(attempts, minRetryBackoff, maxRetryBackoff)); err != nil {
-					return total, err
-				}
-				continue
+	r.triggerOnProcess(v.workspace, v.eventSink, expireAt)
+	start := time.Now()
+	defer func() {
+		r.triggerOnComplete(v.workspace, v.eventSink, total, time.Since(start), err)
+		span.SetAttributes(TRACE_ATTR_COUNT.Int64(total))
+		endSpan(span, err)
+	}()
+
+	var lastErr error
+	for attempt := 0; attempt <= attempts; attempt++ {
+		expired, trim, err := v.executeContext(ctx, expireAt)
+		if trim.Trimmed > 0 {
+			r.reportTrim(v, trim)
+		}
+		total = total + expired
+		v.count = total
+		if err == nil {
+			if v.retrying {
+				v.retrying = false
+				resolveLogger(r.Logger).Info("recovered removing expired leases",
+					LOG_FIELD_WORKSPACE, v.workspace,
+					LOG_FIELD_EVENT_SINK, v.eventSink)
+				r.triggerOnRecover(v.workspace, v.eventSink)
 			}
-			lastErr = err
+			return total, nil
+		}
+		if !helper.IsRetriableError(err, true) {
+			return total, err
+		}
+		lastErr = err
+		if attempt == attempts {
 			break
 		}
+
+		if !v.retrying {
+			v.retrying = true
+			resolveLogger(r.Logger).Warn("retrying to remove expired leases",
+				LOG_FIELD_WORKSPACE, v.workspace,
+				LOG_FIELD_EVENT_SINK, v.eventSink,
+				LOG_FIELD_ERROR, err)
+			r.triggerOnRetry(v.workspace, v.eventSink, expireAt)
+		}
+		if err := helper.Sleep(r.ctx, helper.RetryBackoff(attempt, minRetryBackoff, maxRetryBackoff)); err != nil {
+			return total, err
+		}
 	}
+	// the retries are exhausted; the FailurePolicy applies to the last error
 	return total, lastErr
 }
 
@@ -312,6 +780,14 @@ func (r *LeaseReaper) triggerOnProcess(workspace, eventSink string, expireAt tim
 	}
 }
 
+func (r *LeaseReaper) triggerOnComplete(workspace, eventSink string, count int64, elapsed time.Duration, err error) {
+	for _, h := range r.hooks {
+		if h, ok := h.(LeaseReaperCompletionHook); ok {
+			h.OnComplete(r, workspace, eventSink, count, elapsed, err)
+		}
+	}
+}
+
 func (r *LeaseReaper) triggerOnRecover(workspace, eventSink string) {
 	for _, h := range r.hooks {
 		h.OnRecover(r, workspace, eventSink)
@@ -335,3 +811,49 @@ func (r *LeaseReaper) triggerOnStop() {
 		h.OnStop(r)
 	}
 }
+
+func (r *LeaseReaper) reportTrim(v *LeaseExpireExecutor, trim SinkTrimResult) {
+	if len(trim.UndeliveredGroups) > 0 {
+		resolveLogger(r.Logger).Warn("trimmed events not delivered to some consumer groups yet",
+			LOG_FIELD_WORKSPACE, v.workspace,
+			LOG_FIELD_EVENT_SINK, v.eventSink,
+			"trimmed", trim.Trimmed,
+			"groups", strings.Join(trim.UndeliveredGroups, ","))
+	}
+	if len(trim.PendingGroups) > 0 {
+		resolveLogger(r.Logger).Warn("trimmed events not acknowledged by some consumer groups yet",
+			LOG_FIELD_WORKSPACE, v.workspace,
+			LOG_FIELD_EVENT_SINK, v.eventSink,
+			"trimmed", trim.Trimmed,
+			"groups", strings.Join(trim.PendingGroups, ","))
+	}
+	for _, h := range r.hooks {
+		if h, ok := h.(LeaseReaperTrimHook); ok {
+			h.OnTrim(r, v.workspace, v.eventSink, trim)
+		}
+	}
+}
+
+func (r *LeaseReaper) triggerOnFailure(workspace, eventSink string, err error) {
+	for _, h := range r.hooks {
+		if h, ok := h.(LeaseReaperFailureHook); ok {
+			h.OnFailure(r, workspace, eventSink, r.FailurePolicy, err)
+		}
+	}
+}
+
+func (r *LeaseReaper) triggerOnActive() {
+	for _, h := range r.hooks {
+		if h, ok := h.(LeaseReaperActivityHook); ok {
+			h.OnActive(r)
+		}
+	}
+}
+
+func (r *LeaseReaper) triggerOnStandby() {
+	for _, h := range r.hooks {
+		if h, ok := h.(LeaseReaperActivityHook); ok {
+			h.OnStandby(r)
+		}
+	}
+}
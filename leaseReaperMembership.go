@@ -0,0 +1,179 @@
+package lease
+
+import (
+	"context"
+	"hash/fnv"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/bcowtech/lib-redis-lease/internal"
+	"github.com/bcowtech/lib-redis-lease/internal/helper"
+)
+
+// reaperMembership keeps the member lease of a LeaseReaper alive in the
+// partition workspace, and tracks the live members to decide which
+// workspaces the LeaseReaper owns.
+type reaperMembership struct {
+	lessor       *Lessor
+	lister       internal.LeaseLister
+	workspace    string
+	memberID     string
+	ttl          time.Duration
+	errorHandler func(err error)
+
+	members atomic.Value // []string
+}
+
+func (m *reaperMembership) run(ctx context.Context) {
+	var interval = m.ttl / 3
+
+	for ctx.Err() == nil {
+		session := &Session{
+			Lessor:    m.lessor,
+			Workspace: m.workspace,
+			Lease: Lease{
+				ID:  m.memberID,
+				TTL: m.ttl,
+			},
+		}
+		if err := session.Open(ctx); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			m.errorHandler(err)
+			helper.Sleep(ctx, interval)
+			continue
+		}
+
+		m.refresh(ctx)
+		m.watch(ctx, session, interval)
+	}
+}
+
+func (m *reaperMembership) watch(ctx context.Context, session *Session, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			// leave at once, so the other members take over without
+			// waiting for the member lease to expire
+			m.members.Store([]string(nil))
+			if err := session.Close(context.Background()); err != nil {
+				m.errorHandler(err)
+			}
+			return
+
+		case <-session.Done():
+			m.members.Store([]string(nil))
+			m.errorHandler(session.Err())
+			return
+
+		case <-ticker.C:
+			m.refresh(ctx)
+		}
+	}
+}
+
+func (m *reaperMembership) refresh(ctx context.Context) {
+	now := time.Now()
+	members, err := m.lister.ListAliveContext(ctx, m.workspace, now)
+	if err != nil {
+		if ctx.Err() == nil {
+			m.errorHandler(err)
+		}
+		return
+	}
+	m.purge(ctx, now)
+
+	var found bool
+	for _, v := range members {
+		if v == m.memberID {
+			found = true
+			break
+		}
+	}
+	if !found {
+		members = append(members, m.memberID)
+	}
+	sort.Strings(members)
+
+	m.members.Store(members)
+}
+
+// purge deletes the member leases expired for more than a TTL, which the
+// crashed members leave behind. The partition workspace has no expiry
+// contract, so nobody else sweeps it. A member coming back meanwhile loses its
+// lease once and joins again.
+func (m *reaperMembership) purge(ctx context.Context, now time.Time) {
+	pager, ok := m.lessor.store.(internal.LeasePager)
+	if !ok {
+		return
+	}
+
+	page, err := pager.ListContext(ctx, m.workspace, internal.ListOptions{
+		MaxExpireAt: Timestamp(now.Add(-m.ttl).UnixNano() / int64(time.Millisecond)),
+		Limit:       DEFAULT_LIST_LIMIT,
+	})
+	if err != nil {
+		if ctx.Err() == nil {
+			m.errorHandler(err)
+		}
+		return
+	}
+	for _, lease := range page.Leases {
+		if lease.ID == m.memberID {
+			continue
+		}
+		if _, err := m.lessor.store.DeleteContext(ctx, m.workspace, lease.ID); err != nil {
+			if ctx.Err() == nil {
+				m.errorHandler(err)
+			}
+			return
+		}
+	}
+}
+
+// owns reports whether the workspace is assigned to the member. Nothing is
+// assigned until the member has joined.
+func (m *reaperMembership) owns(workspace string) bool {
+	members, _ := m.members.Load().([]string)
+	if len(members) == 0 {
+		return false
+	}
+	return rendezvousOwner(members, workspace) == m.memberID
+}
+
+// rendezvousOwner picks the member with the highest hash of the member and the
+// key. Only the keys of a leaving member move, and they spread evenly over the
+// remaining members.
+func rendezvousOwner(members []string, key string) string {
+	var (
+		owner string
+		score uint64
+	)
+	for _, member := range members {
+		h := fnv.New64a()
+		h.Write([]byte(member))
+		h.Write([]byte{0})
+		h.Write([]byte(key))
+
+		if v := mix64(h.Sum64()); len(owner) == 0 || v > score {
+			owner, score = member, v
+		}
+	}
+	return owner
+}
+
+// mix64 is the finalizer of MurmurHash3. FNV alone orders the members the
+// same way for most keys when their IDs differ in a single byte.
+func mix64(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
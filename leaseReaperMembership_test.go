@@ -0,0 +1,101 @@
+package lease
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRendezvousOwner(t *testing.T) {
+	var (
+		members   = []string{"member-1", "member-2", "member-3"}
+		remaining = []string{"member-1", "member-3"}
+		counts    = make(map[string]int)
+	)
+
+	for i := 0; i < 300; i++ {
+		key := fmt.Sprintf("op/workspace-%d", i)
+
+		owner := rendezvousOwner(members, key)
+		counts[owner]++
+
+		// stable whatever the order of the members
+		if v := rendezvousOwner([]string{"member-3", "member-1", "member-2"}, key); v != owner {
+			t.Errorf("%s: expect %v, but got %v", key, owner, v)
+		}
+
+		// only the keys of the leaving member move
+		if v := rendezvousOwner(remaining, key); owner != "member-2" && v != owner {
+			t.Errorf("%s: expect %v to keep the key, but got %v", key, owner, v)
+		}
+	}
+
+	for _, member := range members {
+		if counts[member] < 50 {
+			t.Errorf("%s: expect an even share of the keys, but got %v", member, counts[member])
+		}
+	}
+
+	if v := rendezvousOwner(nil, "op/workspace"); len(v) != 0 {
+		t.Errorf("expect an empty owner, but got %v", v)
+	}
+}
+
+func TestReaperMembership_Refresh(t *testing.T) {
+	var (
+		store  = NewMemoryLeaseStore()
+		lessor = createTestLessor(t, store)
+		ctx    = context.Background()
+		now    = time.Now()
+		ttl    = time.Second
+	)
+
+	// member-2 is alive, member-3 crashed long ago
+	lessor.Grant("op/members", Lease{ID: "member-2", TTL: ttl}, now)
+	lessor.Grant("op/members", Lease{ID: "member-3", TTL: ttl}, now.Add(-3*ttl))
+
+	m := &reaperMembership{
+		lessor:    lessor,
+		lister:    store,
+		workspace: "op/members",
+		memberID:  "member-1",
+		ttl:       ttl,
+		errorHandler: func(err error) {
+			t.Error(err)
+		},
+	}
+	if m.owns("op/workspace") {
+		t.Errorf("nothing should be owned before joining")
+	}
+
+	m.refresh(ctx)
+
+	members, _ := m.members.Load().([]string)
+	var expectedMembers = []string{"member-1", "member-2"}
+	if fmt.Sprint(members) != fmt.Sprint(expectedMembers) {
+		t.Errorf("expect %v, but got %v", expectedMembers, members)
+	}
+
+	lease, err := lessor.Lease("op/members", "member-3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lease != nil {
+		t.Errorf("the lease of the crashed member should be deleted")
+	}
+
+	var owned int
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("op/workspace-%d", i)
+		if m.owns(key) {
+			owned++
+			if v := rendezvousOwner(members, key); v != "member-1" {
+				t.Errorf("%s: expect %v, but got %v", key, "member-1", v)
+			}
+		}
+	}
+	if owned == 0 || owned == 100 {
+		t.Errorf("expect a share of the workspaces, but got %v", owned)
+	}
+}
@@ -0,0 +1,425 @@
+package lease
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type activityRecorder struct {
+	LeaseReaperHook
+	active  int32
+	standby int32
+}
+
+func (h *activityRecorder) OnActive(sender *LeaseReaper)  { atomic.AddInt32(&h.active, 1) }
+func (h *activityRecorder) OnStandby(sender *LeaseReaper) { atomic.AddInt32(&h.standby, 1) }
+
+func (h *activityRecorder) OnStart(sender *LeaseReaper) {}
+func (h *activityRecorder) OnStop(sender *LeaseReaper)  {}
+
+func waitUntil(t *testing.T, timeout time.Duration, cond func() bool) bool {
+	t.Helper()
+
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return true
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return cond()
+}
+
+func TestLeaseReaper_HA_StandbyOnTransientErrors(t *testing.T) {
+	var (
+		shared = NewMemoryLeaseStore()
+		store1 = &faultyLeaseStore{LeaseStore: shared}
+		store2 = &faultyLeaseStore{LeaseStore: shared}
+		hook1  = new(activityRecorder)
+	)
+
+	create := func(store LeaseStore, candidate string) *LeaseReaper {
+		r := &LeaseReaper{
+			PollingTimeout: 10 * time.Millisecond,
+			Store:          store,
+			Logger:         NopLogger{},
+			HAWorkspace:    "op/reaper",
+			HALeaseTTL:     100 * time.Millisecond,
+			HACandidate:    candidate,
+		}
+		r.Init()
+		return r
+	}
+
+	r1 := create(store1, "reaper-1")
+	r1.AddHook(hook1)
+	if err := r1.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer r1.Stop()
+
+	if !waitUntil(t, time.Second, r1.IsActive) {
+		t.Fatalf("reaper-1 should be active")
+	}
+
+	r2 := create(store2, "reaper-2")
+	if err := r2.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer r2.Stop()
+
+	// the renewals of reaper-1 fail until its lease expires
+	atomic.StoreInt32(&store1.failing, 1)
+
+	if !waitUntil(t, time.Second, func() bool { return !r1.IsActive() }) {
+		t.Fatalf("reaper-1 should go on standby")
+	}
+	if n := atomic.LoadInt32(&hook1.standby); n != 1 {
+		t.Errorf("OnStandby: expect %v, but got %v", 1, n)
+	}
+	if !waitUntil(t, time.Second, r2.IsActive) {
+		t.Errorf("reaper-2 should take over")
+	}
+}
+
+// blockingLeaseStore counts the concurrent expirations, each taking delay.
+type blockingLeaseStore struct {
+	LeaseStore
+	delay time.Duration
+	fail  map[string]error
+
+	mutex    sync.Mutex
+	inFlight int
+	peak     int
+	calls    int
+}
+
+func (s *blockingLeaseStore) ExpireContext(ctx context.Context, workspace, sink string, timestamp time.Time, options ...*LeaseArg) (int64, error) {
+	s.mutex.Lock()
+	s.calls++
+	s.inFlight++
+	if s.inFlight > s.peak {
+		s.peak = s.inFlight
+	}
+	s.mutex.Unlock()
+
+	time.Sleep(s.delay)
+
+	s.mutex.Lock()
+	s.inFlight--
+	s.mutex.Unlock()
+
+	if err := s.fail[workspace]; err != nil {
+		return 0, err
+	}
+	return 1, nil
+}
+
+func createTestReaper(t *testing.T, store LeaseStore, concurrency int, workspaces ...string) *LeaseReaper {
+	r := &LeaseReaper{
+		Store:       store,
+		Logger:      NopLogger{},
+		Concurrency: concurrency,
+	}
+	r.Init()
+	for _, workspace := range workspaces {
+		err := r.AddExpiryContracts(&LeaseExpiryContract{
+			Workspace: workspace,
+			EventSink: workspace + "/events",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return r
+}
+
+func TestLeaseReaper_RemoveExpiredLeases_Concurrency(t *testing.T) {
+	for _, concurrency := range []int{0, 1, 3, 10} {
+		store := &blockingLeaseStore{
+			LeaseStore: NewMemoryLeaseStore(),
+			delay:      20 * time.Millisecond,
+		}
+		r := createTestReaper(t, store, concurrency, "op/a", "op/b", "op/c", "op/d", "op/e")
+
+		count, err := r.removeExpiredLeases(r.executors, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var expectedCount int64 = 5
+		if count != expectedCount {
+			t.Errorf("Concurrency %d: expect %v, but got %v", concurrency, expectedCount, count)
+		}
+
+		expectedPeak := concurrency
+		if expectedPeak < 1 {
+			expectedPeak = 1
+		}
+		if expectedPeak > 5 {
+			expectedPeak = 5
+		}
+		if store.peak != expectedPeak {
+			t.Errorf("Concurrency %d: expect a peak of %v, but got %v", concurrency, expectedPeak, store.peak)
+		}
+		for _, v := range r.executors {
+			if v.count != 1 || v.err != nil {
+				t.Errorf("Concurrency %d: %s: unexpected state count=%v err=%v", concurrency, v.workspace, v.count, v.err)
+			}
+		}
+	}
+}
+
+func TestLeaseReaper_RemoveExpiredLeases_Error(t *testing.T) {
+	store := &blockingLeaseStore{
+		LeaseStore: NewMemoryLeaseStore(),
+		fail:       map[string]error{"op/b": errStoreUnavailable},
+	}
+	r := createTestReaper(t, store, 2, "op/a", "op/b", "op/c")
+
+	count, err := r.removeExpiredLeases(r.executors, time.Now())
+	if err != errStoreUnavailable {
+		t.Errorf("expect %v, but got %v", errStoreUnavailable, err)
+	}
+	var expectedCount int64 = 2
+	if count != expectedCount {
+		t.Errorf("expect %v, but got %v", expectedCount, count)
+	}
+
+	// the failure is kept per contract
+	for _, v := range r.executors {
+		var expectedErr error
+		if v.workspace == "op/b" {
+			expectedErr = errStoreUnavailable
+		}
+		if v.err != expectedErr {
+			t.Errorf("%s: expect %v, but got %v", v.workspace, expectedErr, v.err)
+		}
+	}
+}
+
+func TestLeaseReaper_RemoveExpiredLeases_RetriesExhausted(t *testing.T) {
+	store := &blockingLeaseStore{
+		LeaseStore: NewMemoryLeaseStore(),
+		fail:       map[string]error{"op/a": io.EOF},
+	}
+	r := createTestReaper(t, store, 1, "op/a")
+	r.maxRetries = 2
+	r.minRetryBackoff = time.Millisecond
+	r.maxRetryBackoff = time.Millisecond
+
+	_, err := r.removeExpiredLeases(r.executors, time.Now())
+	if err != io.EOF {
+		t.Errorf("expect %v, but got %v", io.EOF, err)
+	}
+	if r.executors[0].err != io.EOF {
+		t.Errorf("expect %v, but got %v", io.EOF, r.executors[0].err)
+	}
+	if store.calls != 3 {
+		t.Errorf("expect %v calls, but got %v", 3, store.calls)
+	}
+}
+
+func TestLeaseReaper_StopOnFailure(t *testing.T) {
+	store := &blockingLeaseStore{
+		LeaseStore: NewMemoryLeaseStore(),
+		fail:       map[string]error{"op/a": errStoreUnavailable},
+	}
+	r := createTestReaper(t, store, 1, "op/a")
+	r.PollingTimeout = 10 * time.Millisecond
+	if err := r.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-r.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("the reaper should stop on the failure")
+	}
+	if !errors.Is(r.Err(), errStoreUnavailable) {
+		t.Errorf("expect the error to wrap %v, but got %v", errStoreUnavailable, r.Err())
+	}
+
+	// the stopped reaper is no longer running
+	returned := make(chan struct{})
+	go func() {
+		r.Pause()
+		r.Pause()
+		r.Resume()
+		r.Stop()
+		close(returned)
+	}()
+	select {
+	case <-returned:
+	case <-time.After(time.Second):
+		t.Fatalf("Pause, Resume and Stop should not block once the reaper stopped")
+	}
+}
+
+func TestLeaseReaper_AddExpiryContractsAfterStart(t *testing.T) {
+	store := NewMemoryLeaseStore()
+	lessor := createTestLessor(t, store)
+
+	r := &LeaseReaper{
+		PollingTimeout: 10 * time.Millisecond,
+		IdlingTimeout:  time.Hour,
+		Store:          store,
+		Logger:         NopLogger{},
+	}
+	r.Init()
+	if err := r.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer r.Stop()
+
+	lessor.Grant("op/late", Lease{ID: "lease-1", TTL: time.Millisecond}, time.Now().Add(-time.Second))
+
+	err := r.AddExpiryContracts(&LeaseExpiryContract{
+		Workspace: "op/late",
+		EventSink: "op/late/events",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !waitUntil(t, time.Second, func() bool { return len(store.Events("op/late/events")) > 0 }) {
+		t.Fatalf("the contract added after Start should be swept")
+	}
+	events := store.Events("op/late/events")
+	if events[0].Action != ACTION_EXPIRED || events[0].LeaseID != "lease-1" {
+		t.Errorf("unexpected event %+v", events[0])
+	}
+}
+
+func TestLeaseReaper_AddExpiryContracts_Duplicated(t *testing.T) {
+	store := NewMemoryLeaseStore()
+	r := createTestReaper(t, store, 1, "op/a")
+
+	err := r.AddExpiryContracts(&LeaseExpiryContract{Workspace: "op/a", EventSink: "op/a/events"})
+	if err == nil {
+		t.Errorf("expect an error adding the workspace '%s' twice", "op/a")
+	}
+	err = r.AddExpiryContracts(
+		&LeaseExpiryContract{Workspace: "op/b", EventSink: "op/b/events"},
+		&LeaseExpiryContract{Workspace: "op/b", EventSink: "op/b/events"})
+	if err == nil {
+		t.Errorf("expect an error adding the workspace '%s' twice", "op/b")
+	}
+	if len(r.executors) != 2 {
+		t.Errorf("expect %v contracts, but got %v", 2, len(r.executors))
+	}
+
+	// the same applies once started
+	r.PollingTimeout = 10 * time.Millisecond
+	if err := r.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer r.Stop()
+
+	err = r.AddExpiryContracts(&LeaseExpiryContract{Workspace: "op/a", EventSink: "op/a/events"})
+	if err == nil {
+		t.Errorf("expect an error adding the workspace '%s' twice", "op/a")
+	}
+	err = r.AddExpiryContracts(&LeaseExpiryContract{Workspace: "op/c", EventSink: "op/c/events"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.executors) != 3 {
+		t.Errorf("expect %v contracts, but got %v", 3, len(r.executors))
+	}
+}
+
+type failureRecorder struct {
+	LeaseReaperHook
+	policies []FailurePolicy
+}
+
+func (h *failureRecorder) OnFailure(sender *LeaseReaper, workspace, eventSink string, policy FailurePolicy, err error) {
+	h.policies = append(h.policies, policy)
+}
+
+func TestLeaseReaper_SuperviseExecutors(t *testing.T) {
+	cases := []struct {
+		policy        FailurePolicy
+		handled       bool
+		expectStop    bool
+		expectPending int
+	}{
+		{FAILURE_POLICY_STOP, false, true, 1},
+		{FAILURE_POLICY_RESTART, false, false, 2},
+		{FAILURE_POLICY_SKIP, false, false, 1},
+		// errors handled by the ErrorHandler never apply the policy
+		{FAILURE_POLICY_STOP, true, false, 2},
+	}
+
+	for _, c := range cases {
+		hook := new(failureRecorder)
+		r := &LeaseReaper{
+			Store:             NewMemoryLeaseStore(),
+			Logger:            NopLogger{},
+			FailurePolicy:     c.policy,
+			RestartMinBackoff: time.Second,
+			RestartMaxBackoff: 4 * time.Second,
+			ErrorHandler: func(err error) bool {
+				return c.handled
+			},
+		}
+		r.Init()
+		r.AddHook(hook)
+		for _, workspace := range []string{"op/ok", "op/failed"} {
+			r.AddExpiryContracts(&LeaseExpiryContract{
+				Workspace:      workspace,
+				EventSink:      workspace + "/events",
+				PollingTimeout: 10 * time.Millisecond,
+				IdlingTimeout:  time.Minute,
+			})
+		}
+		failed := r.executors[1]
+
+		var (
+			schedule leaseExpireSchedule
+			now      = time.Now()
+		)
+		for attempt := 0; attempt < 3; attempt++ {
+			schedule = schedule[:0]
+			failed.err = errStoreUnavailable
+			err := r.superviseExecutors(&schedule, r.executors, now)
+			if (err != nil) != c.expectStop {
+				t.Errorf("%s: unexpected error %v", c.policy, err)
+			}
+			if err != nil && !errors.Is(err, errStoreUnavailable) {
+				t.Errorf("%s: expect the error to wrap %v, but got %v", c.policy, errStoreUnavailable, err)
+			}
+			if len(schedule) != c.expectPending {
+				t.Errorf("%s: expect %v scheduled executors, but got %v", c.policy, c.expectPending, len(schedule))
+			}
+			if failed.err != nil {
+				t.Errorf("%s: the error should be cleared", c.policy)
+			}
+		}
+
+		if c.handled {
+			if len(hook.policies) != 0 {
+				t.Errorf("%s: OnFailure should not be called", c.policy)
+			}
+			continue
+		}
+		if len(hook.policies) != 3 || hook.policies[0] != c.policy {
+			t.Errorf("%s: unexpected OnFailure calls %v", c.policy, hook.policies)
+		}
+		if c.policy == FAILURE_POLICY_RESTART {
+			// the backoff grows across the consecutive failures, jittered
+			// up to RestartMaxBackoff
+			if failed.failures != 3 {
+				t.Errorf("expect %v failures, but got %v", 3, failed.failures)
+			}
+			if wait := failed.nextAt.Sub(now); wait < 0 || wait > 4*time.Second {
+				t.Errorf("unexpected restart backoff %v", wait)
+			}
+		}
+	}
+}
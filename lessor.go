@@ -1,50 +1,240 @@
 package lease
 
 import (
+	"context"
+	"fmt"
+	"sync"
 	"time"
 
 	"github.com/bcowtech/lib-redis-lease/internal"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Lessor struct {
-	RedisOption *RedisOption
+	RedisOption    *RedisOption
+	KeyPrefix      string
+	ClusterMode    bool
+	Store          LeaseStore
+	TracerProvider trace.TracerProvider
+	Logger         Logger
 
-	provider *internal.LeaseProvider
+	store    LeaseStore
+	keyspace *internal.KeySpace
+	tracer   trace.Tracer
+
+	eventSinks      map[string]string
+	eventSinksMutex sync.RWMutex
+
+	hooks []LessorHook
 }
 
 func (l *Lessor) Init() error {
-	provider := new(internal.LeaseProvider)
-	{
-		client, err := CreateRedisUniversalClient(l.RedisOption)
-		if err != nil {
+	keyspace := internal.NewKeySpace(l.KeyPrefix)
+	if l.ClusterMode {
+		if err := keyspace.ValidateClusterPrefix(); err != nil {
+			return err
+		}
+	}
+
+	store := l.Store
+	if store == nil {
+		provider := &internal.LeaseProvider{
+			KeyPrefix: l.KeyPrefix,
+		}
+		{
+			client, err := createRedisClient(l.RedisOption, l.ClusterMode)
+			if err != nil {
+				return err
+			}
+			provider.Init(client)
+		}
+		store = provider
+	}
+
+	l.store = store
+	l.keyspace = keyspace
+	l.tracer = createTracer(l.TracerProvider)
+
+	return nil
+}
+
+// AddHook adds a hook which is called after Grant, KeepAlive and Revoke and
+// their batch variants. If the hook also implements LeaseScriptHook and the
+// Lessor uses the Redis store, it is called after each script execution too.
+func (l *Lessor) AddHook(hook LessorHook) {
+	l.hooks = append(l.hooks, hook)
+
+	if h, ok := hook.(LeaseScriptHook); ok {
+		if provider, ok := l.store.(*internal.LeaseProvider); ok {
+			provider.AddHook(h)
+		}
+	}
+}
+
+// BindEventSink binds the workspace to the specified event sink. Once bound,
+// Grant, KeepAlive and Revoke on the workspace atomically append GRANTED,
+// RENEWED and REVOKED events to the sink.
+func (l *Lessor) BindEventSink(workspace, sink string) error {
+	if len(workspace) == 0 {
+		logger.Panic("specified argument 'workspace' cannot be an empty string")
+	}
+	if len(sink) == 0 {
+		logger.Panic("specified argument 'sink' cannot be an empty string")
+	}
+	if l.ClusterMode {
+		if err := l.keyspace.ValidateClusterKeys(workspace, sink); err != nil {
 			return err
 		}
-		provider.Init(client)
 	}
 
-	l.provider = provider
+	l.eventSinksMutex.Lock()
+	defer l.eventSinksMutex.Unlock()
 
+	if l.eventSinks == nil {
+		l.eventSinks = make(map[string]string)
+	}
+	l.eventSinks[workspace] = sink
 	return nil
 }
 
+func (l *Lessor) UnbindEventSink(workspace string) {
+	l.eventSinksMutex.Lock()
+	defer l.eventSinksMutex.Unlock()
+
+	delete(l.eventSinks, workspace)
+}
+
 func (l *Lessor) Grant(workspace string, lease Lease, timestamp time.Time) (ok bool, err error) {
-	return l.provider.Put(workspace, lease.ID, lease.TTL, timestamp)
+	return l.GrantContext(context.Background(), workspace, lease, timestamp)
+}
+
+func (l *Lessor) GrantContext(ctx context.Context, workspace string, lease Lease, timestamp time.Time) (ok bool, err error) {
+	ok, _, err = l.grant(ctx, workspace, lease, timestamp)
+	return ok, err
+}
+
+// GrantWithRevision puts the lease like Grant and returns its revision, a
+// fencing token which is strictly increasing within the workspace. A zero
+// revision means the lease was not granted. It returns
+// ErrRevisionNotSupported if the Store is not a LeaseRevisionStore.
+func (l *Lessor) GrantWithRevision(workspace string, lease Lease, timestamp time.Time) (revision int64, err error) {
+	return l.GrantWithRevisionContext(context.Background(), workspace, lease, timestamp)
+}
+
+func (l *Lessor) GrantWithRevisionContext(ctx context.Context, workspace string, lease Lease, timestamp time.Time) (revision int64, err error) {
+	if _, ok := l.store.(LeaseRevisionStore); !ok {
+		return 0, ErrRevisionNotSupported
+	}
+	_, revision, err = l.grant(ctx, workspace, lease, timestamp)
+	return revision, err
+}
+
+// grant puts the lease; the revision is zero unless the Store is a
+// LeaseRevisionStore.
+func (l *Lessor) grant(ctx context.Context, workspace string, lease Lease, timestamp time.Time) (ok bool, revision int64, err error) {
+	if err := l.validateWorkspace(workspace); err != nil {
+		return false, 0, err
+	}
+
+	ctx, span := l.startSpan(ctx, "lease.Grant", workspace, TRACE_ATTR_LEASE_ID.String(lease.ID))
+	defer func() {
+		span.SetAttributes(TRACE_ATTR_REVISION.Int64(revision))
+		endSpan(span, err)
+	}()
+
+	ok, revision, err = internal.PutWithRevision(ctx, l.store, workspace, lease.ID, lease.TTL, timestamp,
+		WithMetadata(lease.Metadata),
+		traceParentArg(ctx),
+		l.eventSinkArg(workspace))
+	l.triggerOnGrant(workspace, lease.ID, ok, revision, err)
+	return ok, revision, err
 }
 
 func (l *Lessor) KeepAlive(workspace, leaseKey string, timestamp time.Time) (Timestamp, error) {
-	return l.provider.Renew(workspace, leaseKey, timestamp)
+	return l.KeepAliveContext(context.Background(), workspace, leaseKey, timestamp)
+}
+
+func (l *Lessor) KeepAliveContext(ctx context.Context, workspace, leaseKey string, timestamp time.Time) (Timestamp, error) {
+	if err := l.validateWorkspace(workspace); err != nil {
+		return 0, err
+	}
+	ctx, span := l.startSpan(ctx, "lease.KeepAlive", workspace, TRACE_ATTR_LEASE_ID.String(leaseKey))
+
+	expireAt, err := l.store.RenewContext(ctx, workspace, leaseKey, timestamp, l.eventSinkArg(workspace))
+	l.triggerOnKeepAlive(workspace, leaseKey, expireAt, err)
+	if err == ErrStaleRenewal {
+		// the lease is alive until its current expiry
+		err = nil
+	}
+	endSpan(span, err)
+	return expireAt, err
 }
 
 func (l *Lessor) Revoke(workspace, leaseKey string) (ok bool, err error) {
-	return l.provider.Delete(workspace, leaseKey)
+	return l.RevokeContext(context.Background(), workspace, leaseKey)
+}
+
+func (l *Lessor) RevokeContext(ctx context.Context, workspace, leaseKey string) (ok bool, err error) {
+	if err := l.validateWorkspace(workspace); err != nil {
+		return false, err
+	}
+	ctx, span := l.startSpan(ctx, "lease.Revoke", workspace, TRACE_ATTR_LEASE_ID.String(leaseKey))
+
+	ok, err = l.store.DeleteContext(ctx, workspace, leaseKey, l.eventSinkArg(workspace))
+	l.triggerOnRevoke(workspace, leaseKey, ok, err)
+	endSpan(span, err)
+	return ok, err
 }
 
 func (l *Lessor) Lease(workspace, leaseKey string) (*Lease, error) {
-	return l.provider.Get(workspace, leaseKey)
+	return l.LeaseContext(context.Background(), workspace, leaseKey)
+}
+
+func (l *Lessor) LeaseContext(ctx context.Context, workspace, leaseKey string) (*Lease, error) {
+	if err := l.validateWorkspace(workspace); err != nil {
+		return nil, err
+	}
+
+	ctx, span := l.startSpan(ctx, "lease.Lease", workspace, TRACE_ATTR_LEASE_ID.String(leaseKey))
+
+	lease, err := l.store.GetContext(ctx, workspace, leaseKey)
+	endSpan(span, err)
+	return lease, err
+}
+
+// List returns a page of the leases in the workspace ordered by expiry. Pass
+// the Cursor of the page in the options to fetch the next one.
+func (l *Lessor) List(workspace string, opts ListOptions) (*LeasePage, error) {
+	return l.ListContext(context.Background(), workspace, opts)
+}
+
+func (l *Lessor) ListContext(ctx context.Context, workspace string, opts ListOptions) (*LeasePage, error) {
+	if err := l.validateWorkspace(workspace); err != nil {
+		return nil, err
+	}
+
+	pager, ok := l.store.(internal.LeasePager)
+	if !ok {
+		return nil, fmt.Errorf("the Store does not support listing leases")
+	}
+
+	ctx, span := l.startSpan(ctx, "lease.List", workspace)
+
+	page, err := pager.ListContext(ctx, workspace, opts)
+	if page != nil {
+		span.SetAttributes(TRACE_ATTR_COUNT.Int(len(page.Leases)))
+	}
+	endSpan(span, err)
+	return page, err
 }
 
 func (l *Lessor) TimeToLive(workspace, leaseKey string) (*time.Duration, error) {
-	lease, err := l.provider.Get(workspace, leaseKey)
+	return l.TimeToLiveContext(context.Background(), workspace, leaseKey)
+}
+
+func (l *Lessor) TimeToLiveContext(ctx context.Context, workspace, leaseKey string) (*time.Duration, error) {
+	lease, err := l.LeaseContext(ctx, workspace, leaseKey)
 	if err != nil {
 		return nil, err
 	}
@@ -53,3 +243,230 @@ func (l *Lessor) TimeToLive(workspace, leaseKey string) (*time.Duration, error)
 	}
 	return nil, nil
 }
+
+func (l *Lessor) GrantMany(workspace string, leases []Lease, timestamp time.Time) ([]GrantResult, error) {
+	return l.GrantManyContext(context.Background(), workspace, leases, timestamp)
+}
+
+func (l *Lessor) GrantManyContext(ctx context.Context, workspace string, leases []Lease, timestamp time.Time) ([]GrantResult, error) {
+	if err := l.validateWorkspace(workspace); err != nil {
+		return nil, err
+	}
+
+	var items = make([]*Lease, len(leases))
+	for i := range leases {
+		items[i] = &leases[i]
+	}
+
+	ctx, span := l.startSpan(ctx, "lease.GrantMany", workspace, TRACE_ATTR_COUNT.Int(len(leases)))
+
+	var (
+		oks       []bool
+		revisions []int64
+		errs      []error
+		err       error
+	)
+	if store, ok := l.store.(LeaseRevisionStore); ok {
+		revisions, errs, err = store.PutManyWithRevisionContext(ctx, workspace, items, timestamp, traceParentArg(ctx), l.eventSinkArg(workspace))
+		oks = make([]bool, len(revisions))
+		for i, revision := range revisions {
+			oks[i] = revision > 0
+		}
+	} else {
+		oks, errs, err = internal.PutMany(ctx, l.store, workspace, items, timestamp, traceParentArg(ctx), l.eventSinkArg(workspace))
+		revisions = make([]int64, len(oks))
+	}
+	endSpan(span, err)
+	if err != nil {
+		for i := range leases {
+			l.triggerOnGrant(workspace, leases[i].ID, false, 0, err)
+		}
+		return nil, err
+	}
+
+	results := make([]GrantResult, len(leases))
+	for i := range leases {
+		results[i] = GrantResult{
+			LeaseID:  leases[i].ID,
+			OK:       oks[i],
+			Revision: revisions[i],
+			Err:      errs[i],
+		}
+		l.triggerOnGrant(workspace, leases[i].ID, oks[i], revisions[i], errs[i])
+	}
+	return results, nil
+}
+
+func (l *Lessor) KeepAliveMany(workspace string, leaseKeys []string, timestamp time.Time) ([]KeepAliveResult, error) {
+	return l.KeepAliveManyContext(context.Background(), workspace, leaseKeys, timestamp)
+}
+
+func (l *Lessor) KeepAliveManyContext(ctx context.Context, workspace string, leaseKeys []string, timestamp time.Time) ([]KeepAliveResult, error) {
+	if err := l.validateWorkspace(workspace); err != nil {
+		return nil, err
+	}
+
+	ctx, span := l.startSpan(ctx, "lease.KeepAliveMany", workspace, TRACE_ATTR_COUNT.Int(len(leaseKeys)))
+
+	expireAts, errs, err := internal.RenewMany(ctx, l.store, workspace, leaseKeys, timestamp, l.eventSinkArg(workspace))
+	endSpan(span, err)
+	if err != nil {
+		for _, key := range leaseKeys {
+			l.triggerOnKeepAlive(workspace, key, 0, err)
+		}
+		return nil, err
+	}
+
+	results := make([]KeepAliveResult, len(leaseKeys))
+	for i, key := range leaseKeys {
+		l.triggerOnKeepAlive(workspace, key, expireAts[i], errs[i])
+		if errs[i] == ErrStaleRenewal {
+			errs[i] = nil
+		}
+		results[i] = KeepAliveResult{
+			LeaseID:  key,
+			ExpireAt: expireAts[i],
+			Err:      errs[i],
+		}
+	}
+	return results, nil
+}
+
+func (l *Lessor) RevokeMany(workspace string, leaseKeys []string) ([]RevokeResult, error) {
+	return l.RevokeManyContext(context.Background(), workspace, leaseKeys)
+}
+
+func (l *Lessor) RevokeManyContext(ctx context.Context, workspace string, leaseKeys []string) ([]RevokeResult, error) {
+	if err := l.validateWorkspace(workspace); err != nil {
+		return nil, err
+	}
+
+	ctx, span := l.startSpan(ctx, "lease.RevokeMany", workspace, TRACE_ATTR_COUNT.Int(len(leaseKeys)))
+
+	oks, errs, err := internal.DeleteMany(ctx, l.store, workspace, leaseKeys, l.eventSinkArg(workspace))
+	endSpan(span, err)
+	if err != nil {
+		for _, key := range leaseKeys {
+			l.triggerOnRevoke(workspace, key, false, err)
+		}
+		return nil, err
+	}
+
+	results := make([]RevokeResult, len(leaseKeys))
+	for i, key := range leaseKeys {
+		results[i] = RevokeResult{
+			LeaseID: key,
+			OK:      oks[i],
+			Err:     errs[i],
+		}
+		l.triggerOnRevoke(workspace, key, oks[i], errs[i])
+	}
+	return results, nil
+}
+
+func (l *Lessor) LeaseMany(workspace string, leaseKeys []string) ([]LeaseResult, error) {
+	return l.LeaseManyContext(context.Background(), workspace, leaseKeys)
+}
+
+func (l *Lessor) LeaseManyContext(ctx context.Context, workspace string, leaseKeys []string) ([]LeaseResult, error) {
+	if err := l.validateWorkspace(workspace); err != nil {
+		return nil, err
+	}
+
+	ctx, span := l.startSpan(ctx, "lease.LeaseMany", workspace, TRACE_ATTR_COUNT.Int(len(leaseKeys)))
+
+	leases, errs, err := internal.GetMany(ctx, l.store, workspace, leaseKeys)
+	endSpan(span, err)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]LeaseResult, len(leaseKeys))
+	for i, key := range leaseKeys {
+		results[i] = LeaseResult{
+			LeaseID: key,
+			Lease:   leases[i],
+			Err:     errs[i],
+		}
+	}
+	return results, nil
+}
+
+func (l *Lessor) Migrate(workspace string) (count int64, err error) {
+	return l.MigrateContext(context.Background(), workspace)
+}
+
+func (l *Lessor) MigrateContext(ctx context.Context, workspace string) (count int64, err error) {
+	if err := l.validateWorkspace(workspace); err != nil {
+		return 0, err
+	}
+	if m, ok := l.store.(internal.LeaseMigrator); ok {
+		return m.MigrateContext(ctx, workspace, DEFAULT_MIGRATE_BATCH_SIZE)
+	}
+	return 0, nil
+}
+
+func (l *Lessor) startSpan(ctx context.Context, name, workspace string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return l.tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(TRACE_ATTR_WORKSPACE.String(workspace)),
+		trace.WithAttributes(attrs...))
+}
+
+func (l *Lessor) validateWorkspace(workspace string) error {
+	if l.ClusterMode {
+		return l.keyspace.ValidateClusterKeys(workspace)
+	}
+	return nil
+}
+
+func (l *Lessor) eventSinkArg(workspace string) *LeaseArg {
+	l.eventSinksMutex.RLock()
+	defer l.eventSinksMutex.RUnlock()
+
+	return &LeaseArg{
+		Name:  internal.LEASE_ARG_SINK,
+		Value: l.eventSinks[workspace],
+	}
+}
+
+func (l *Lessor) triggerOnGrant(workspace, leaseID string, ok bool, revision int64, err error) {
+	if err != nil {
+		resolveLogger(l.Logger).Warn("failed to grant the lease",
+			LOG_FIELD_WORKSPACE, workspace,
+			LOG_FIELD_LEASE_ID, leaseID,
+			LOG_FIELD_ERROR, err)
+	} else {
+		resolveLogger(l.Logger).Debug("granted the lease",
+			LOG_FIELD_WORKSPACE, workspace,
+			LOG_FIELD_LEASE_ID, leaseID,
+			LOG_FIELD_REVISION, revision)
+	}
+	for _, h := range l.hooks {
+		h.OnGrant(l, workspace, leaseID, ok, revision, err)
+	}
+}
+
+func (l *Lessor) triggerOnKeepAlive(workspace, leaseID string, expireAt Timestamp, err error) {
+	if err != nil && err != ErrStaleRenewal {
+		resolveLogger(l.Logger).Warn("failed to keep the lease alive",
+			LOG_FIELD_WORKSPACE, workspace,
+			LOG_FIELD_LEASE_ID, leaseID,
+			LOG_FIELD_ERROR, err)
+	}
+	for _, h := range l.hooks {
+		h.OnKeepAlive(l, workspace, leaseID, expireAt, err)
+	}
+}
+
+func (l *Lessor) triggerOnRevoke(workspace, leaseID string, ok bool, err error) {
+	if err != nil {
+		resolveLogger(l.Logger).Warn("failed to revoke the lease",
+			LOG_FIELD_WORKSPACE, workspace,
+			LOG_FIELD_LEASE_ID, leaseID,
+			LOG_FIELD_ERROR, err)
+	}
+	for _, h := range l.hooks {
+		h.OnRevoke(l, workspace, leaseID, ok, err)
+	}
+}
@@ -0,0 +1,160 @@
+package lease
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+const (
+	LOG_LEVEL_DEBUG LogLevel = iota
+	LOG_LEVEL_INFO
+	LOG_LEVEL_WARN
+	LOG_LEVEL_ERROR
+
+	LOG_FIELD_WORKSPACE  string = "workspace"
+	LOG_FIELD_LEASE_ID   string = "lease_id"
+	LOG_FIELD_EVENT_SINK string = "sink"
+	LOG_FIELD_GROUP      string = "group"
+	LOG_FIELD_REVISION   string = "revision"
+	LOG_FIELD_ERROR      string = "error"
+)
+
+var (
+	_ Logger = new(StdLogger)
+	_ Logger = NopLogger{}
+
+	defaultLogger atomic.Value // loggerHolder
+
+	// logger reports programming errors through the default Logger before
+	// panicking.
+	logger panicLogger
+)
+
+func init() {
+	SetLogger(NewStdLogger(os.Stdout, LOG_LEVEL_INFO))
+}
+
+type LogLevel int
+
+// Logger is a leveled, structured logger. keyvals are alternating keys and
+// values, as taken by zap's SugaredLogger and slog.
+type Logger interface {
+	Debug(msg string, keyvals ...interface{})
+	Info(msg string, keyvals ...interface{})
+	Warn(msg string, keyvals ...interface{})
+	Error(msg string, keyvals ...interface{})
+}
+
+// SetLogger replaces the Logger used by the Lessor, LeaseReaper and Watcher
+// without their own Logger. A nil Logger discards the output.
+func SetLogger(l Logger) {
+	if l == nil {
+		l = NopLogger{}
+	}
+	defaultLogger.Store(loggerHolder{l})
+}
+
+// DefaultLogger returns the Logger set by SetLogger.
+func DefaultLogger() Logger {
+	return defaultLogger.Load().(loggerHolder).Logger
+}
+
+// StdLogger writes "msg key=value ..." lines through a log.Logger.
+type StdLogger struct {
+	level  LogLevel
+	logger *log.Logger
+}
+
+func NewStdLogger(out io.Writer, level LogLevel) *StdLogger {
+	return &StdLogger{
+		level:  level,
+		logger: log.New(out, LOGGER_PREFIX, log.LstdFlags|log.Lmsgprefix),
+	}
+}
+
+func (l *StdLogger) Debug(msg string, keyvals ...interface{}) {
+	l.output(LOG_LEVEL_DEBUG, "DEBUG", msg, keyvals)
+}
+
+func (l *StdLogger) Info(msg string, keyvals ...interface{}) {
+	l.output(LOG_LEVEL_INFO, "INFO", msg, keyvals)
+}
+
+func (l *StdLogger) Warn(msg string, keyvals ...interface{}) {
+	l.output(LOG_LEVEL_WARN, "WARN", msg, keyvals)
+}
+
+func (l *StdLogger) Error(msg string, keyvals ...interface{}) {
+	l.output(LOG_LEVEL_ERROR, "ERROR", msg, keyvals)
+}
+
+func (l *StdLogger) output(level LogLevel, name, msg string, keyvals []interface{}) {
+	if level < l.level {
+		return
+	}
+
+	var sb strings.Builder
+	sb.WriteString(name)
+	sb.WriteByte(' ')
+	sb.WriteString(msg)
+	for i := 0; i < len(keyvals); i += 2 {
+		var value interface{} = "(MISSING)"
+		if i+1 < len(keyvals) {
+			value = keyvals[i+1]
+		}
+		sb.WriteByte(' ')
+		sb.WriteString(fmt.Sprint(keyvals[i]))
+		sb.WriteByte('=')
+		sb.WriteString(formatLogValue(value))
+	}
+	l.logger.Output(3, sb.String())
+}
+
+// NopLogger discards the output.
+type NopLogger struct{}
+
+func (NopLogger) Debug(msg string, keyvals ...interface{}) {}
+func (NopLogger) Info(msg string, keyvals ...interface{})  {}
+func (NopLogger) Warn(msg string, keyvals ...interface{})  {}
+func (NopLogger) Error(msg string, keyvals ...interface{}) {}
+
+// loggerHolder keeps the concrete type stored in defaultLogger consistent.
+type loggerHolder struct {
+	Logger
+}
+
+type panicLogger struct{}
+
+func (panicLogger) Panic(v ...interface{}) {
+	msg := fmt.Sprint(v...)
+	DefaultLogger().Error(msg)
+	panic(msg)
+}
+
+func formatLogValue(v interface{}) string {
+	var str string
+	switch v := v.(type) {
+	case string:
+		str = v
+	case error:
+		str = v.Error()
+	default:
+		str = fmt.Sprint(v)
+	}
+	if len(str) == 0 || strings.ContainsAny(str, " \t\n\"=") {
+		return strconv.Quote(str)
+	}
+	return str
+}
+
+func resolveLogger(l Logger) Logger {
+	if l != nil {
+		return l
+	}
+	return DefaultLogger()
+}
@@ -0,0 +1,99 @@
+package lease
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestFormatLogValue(t *testing.T) {
+	cases := []struct {
+		value    interface{}
+		expected string
+	}{
+		{"lease-1", "lease-1"},
+		{"", `""`},
+		{"op lease", `"op lease"`},
+		{"a=b", `"a=b"`},
+		{"say \"hi\"", `"say \"hi\""`},
+		{"line\nbreak", `"line\nbreak"`},
+		{errors.New("connection refused"), `"connection refused"`},
+		{42, "42"},
+		{int64(-1), "-1"},
+		{true, "true"},
+		{nil, "<nil>"},
+	}
+	for _, c := range cases {
+		if v := formatLogValue(c.value); v != c.expected {
+			t.Errorf("%#v: expect %v, but got %v", c.value, c.expected, v)
+		}
+	}
+}
+
+func TestStdLogger(t *testing.T) {
+	var buf bytes.Buffer
+	l := NewStdLogger(&buf, LOG_LEVEL_INFO)
+
+	l.Debug("hidden")
+	l.Info("granted the lease", LOG_FIELD_WORKSPACE, "op/lease", LOG_FIELD_REVISION, 3)
+	l.Warn("odd", "dangling")
+	l.Error("failed", LOG_FIELD_ERROR, errors.New("i/o timeout"))
+
+	lines := strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
+	var expected = []string{
+		"INFO granted the lease workspace=op/lease revision=3",
+		"WARN odd dangling=(MISSING)",
+		`ERROR failed error="i/o timeout"`,
+	}
+	if len(lines) != len(expected) {
+		t.Fatalf("expect %d lines, but got %q", len(expected), lines)
+	}
+	for i, line := range lines {
+		if !strings.HasSuffix(line, LOGGER_PREFIX+expected[i]) {
+			t.Errorf("line #%d: expect %q, but got %q", i, LOGGER_PREFIX+expected[i], line)
+		}
+	}
+}
+
+func TestSetLogger(t *testing.T) {
+	previous := DefaultLogger()
+	defer SetLogger(previous)
+
+	var buf bytes.Buffer
+	SetLogger(NewStdLogger(&buf, LOG_LEVEL_DEBUG))
+	resolveLogger(nil).Debug("default")
+	if !strings.Contains(buf.String(), "DEBUG default") {
+		t.Errorf("the default Logger should be used, but got %q", buf.String())
+	}
+
+	var own bytes.Buffer
+	resolveLogger(NewStdLogger(&own, LOG_LEVEL_DEBUG)).Debug("own")
+	if !strings.Contains(own.String(), "DEBUG own") || strings.Contains(buf.String(), "own") {
+		t.Errorf("the own Logger should be used")
+	}
+
+	// a nil Logger discards the output
+	SetLogger(nil)
+	if _, ok := DefaultLogger().(NopLogger); !ok {
+		t.Errorf("expect NopLogger, but got %T", DefaultLogger())
+	}
+}
+
+func TestPanicLogger(t *testing.T) {
+	previous := DefaultLogger()
+	defer SetLogger(previous)
+
+	var buf bytes.Buffer
+	SetLogger(NewStdLogger(&buf, LOG_LEVEL_ERROR))
+
+	defer func() {
+		if v := recover(); v != "'Workspace' cannot be an empty string" {
+			t.Errorf("unexpected panic %v", v)
+		}
+		if !strings.Contains(buf.String(), "ERROR 'Workspace' cannot be an empty string") {
+			t.Errorf("the panic should be logged, but got %q", buf.String())
+		}
+	}()
+	logger.Panic("'Workspace' cannot be an empty string")
+}
@@ -0,0 +1,38 @@
+package lease
+
+import (
+	"github.com/bcowtech/lib-redis-lease/internal"
+)
+
+var _ LeaseStore = new(MemoryLeaseStore)
+
+// MemoryLeaseStore is an in-memory LeaseStore for Lessor and LeaseReaper.
+// The events it emits are kept in process memory and can be read back with
+// Events or DrainEvents.
+type MemoryLeaseStore struct {
+	internal.MemoryLeaseStore
+}
+
+func NewMemoryLeaseStore() *MemoryLeaseStore {
+	return new(MemoryLeaseStore)
+}
+
+func (s *MemoryLeaseStore) Events(sink string) []*Event {
+	return s.toEvents(sink, s.Messages(sink))
+}
+
+func (s *MemoryLeaseStore) DrainEvents(sink string) []*Event {
+	return s.toEvents(sink, s.DrainMessages(sink))
+}
+
+func (s *MemoryLeaseStore) toEvents(sink string, messages []XMessage) []*Event {
+	events := make([]*Event, len(messages))
+	for i := range messages {
+		ev := &Event{
+			Sink: sink,
+		}
+		fillEventFromMessage(ev, messages[i].ID, messages[i].Values)
+		events[i] = ev
+	}
+	return events
+}
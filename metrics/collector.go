@@ -0,0 +1,271 @@
+package metrics
+
+import (
+	"time"
+
+	lease "github.com/bcowtech/lib-redis-lease"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	DEFAULT_NAMESPACE = "redis_lease"
+
+	RESULT_OK       = "ok"
+	RESULT_REJECTED = "rejected"
+	RESULT_STALE    = "stale"
+	RESULT_MISSING  = "missing"
+	RESULT_ERROR    = "error"
+)
+
+var (
+	_ lease.LessorHook                = new(Collector)
+	_ lease.LeaseReaperHook           = new(Collector)
+	_ lease.LeaseReaperActivityHook   = new(Collector)
+	_ lease.LeaseReaperCompletionHook = new(Collector)
+	_ lease.LeaseReaperFailureHook    = new(Collector)
+	_ lease.LeaseReaperTrimHook       = new(Collector)
+	_ lease.WatcherHook               = new(Collector)
+	_ lease.WatcherDeadLetterHook     = new(Collector)
+	_ lease.LeaseScriptHook           = new(Collector)
+)
+
+// Collector records Prometheus metrics of the Lessor, the LeaseReaper and the
+// Watcher. Add it as a hook to each of them:
+//
+//	c := &metrics.Collector{Registerer: registry}
+//	if err := c.Init(); err != nil { ... }
+//	lessor.AddHook(c)
+//	reaper.AddHook(c)
+//	watcher.AddHook(c)
+type Collector struct {
+	Namespace  string
+	Registerer prometheus.Registerer
+	Buckets    []float64
+
+	grants          *prometheus.CounterVec
+	renews          *prometheus.CounterVec
+	revokes         *prometheus.CounterVec
+	expired         *prometheus.CounterVec
+	sweepDuration   *prometheus.HistogramVec
+	sweepLag        *prometheus.HistogramVec
+	retries         *prometheus.CounterVec
+	failures        *prometheus.CounterVec
+	trimmed         *prometheus.CounterVec
+	trimUndelivered *prometheus.CounterVec
+	trimPending     *prometheus.CounterVec
+	reaperActive    prometheus.Gauge
+	scriptDuration  *prometheus.HistogramVec
+	handlerErrors   *prometheus.CounterVec
+	handlerDuration *prometheus.HistogramVec
+	deadLetters     *prometheus.CounterVec
+}
+
+func (c *Collector) Init() error {
+	if len(c.Namespace) == 0 {
+		c.Namespace = DEFAULT_NAMESPACE
+	}
+	if c.Registerer == nil {
+		c.Registerer = prometheus.DefaultRegisterer
+	}
+	if len(c.Buckets) == 0 {
+		c.Buckets = prometheus.DefBuckets
+	}
+
+	c.grants = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: c.Namespace,
+		Name:      "grants_total",
+		Help:      "Number of Grant calls by result.",
+	}, []string{"workspace", "result"})
+	c.renews = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: c.Namespace,
+		Name:      "renews_total",
+		Help:      "Number of KeepAlive calls by result; stale means the timestamp was not later than the last renewal, missing that the lease was not found.",
+	}, []string{"workspace", "result"})
+	c.revokes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: c.Namespace,
+		Name:      "revokes_total",
+		Help:      "Number of Revoke calls by result.",
+	}, []string{"workspace", "result"})
+	c.expired = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: c.Namespace,
+		Name:      "expired_leases_total",
+		Help:      "Number of leases expired by the LeaseReaper.",
+	}, []string{"workspace"})
+	c.sweepDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: c.Namespace,
+		Name:      "sweep_duration_seconds",
+		Help:      "Duration of the sweeps of an expiry contract, retries included.",
+		Buckets:   c.Buckets,
+	}, []string{"workspace"})
+	c.sweepLag = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: c.Namespace,
+		Name:      "sweep_lag_seconds",
+		Help:      "Delay between the tick of an expiry contract and the start of its sweep.",
+		Buckets:   c.Buckets,
+	}, []string{"workspace"})
+	c.retries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: c.Namespace,
+		Name:      "sweep_retries_total",
+		Help:      "Number of times an expiry contract started retrying.",
+	}, []string{"workspace"})
+	c.failures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: c.Namespace,
+		Name:      "sweep_failures_total",
+		Help:      "Number of contract failures not handled by the ErrorHandler, by failure policy.",
+	}, []string{"workspace", "policy"})
+	c.trimmed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: c.Namespace,
+		Name:      "sink_trimmed_entries_total",
+		Help:      "Number of entries trimmed from the event sinks by the retention of the contracts.",
+	}, []string{"workspace"})
+	c.trimUndelivered = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: c.Namespace,
+		Name:      "sink_trim_undelivered_total",
+		Help:      "Number of trims which removed entries a consumer group had not been delivered yet.",
+	}, []string{"workspace", "group"})
+	c.trimPending = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: c.Namespace,
+		Name:      "sink_trim_pending_total",
+		Help:      "Number of trims which removed entries a consumer group had not acknowledged yet.",
+	}, []string{"workspace", "group"})
+	c.reaperActive = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: c.Namespace,
+		Name:      "reaper_active",
+		Help:      "Whether the LeaseReaper is the active sweeper in HA mode.",
+	})
+	c.scriptDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: c.Namespace,
+		Name:      "script_duration_seconds",
+		Help:      "Latency of the lease scripts by result.",
+		Buckets:   c.Buckets,
+	}, []string{"script", "result"})
+	c.handlerErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: c.Namespace,
+		Name:      "handler_errors_total",
+		Help:      "Number of events the Watcher EventHandler failed to handle.",
+	}, []string{"action"})
+	c.handlerDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: c.Namespace,
+		Name:      "handler_duration_seconds",
+		Help:      "Latency of the Watcher EventHandler by action.",
+		Buckets:   c.Buckets,
+	}, []string{"action"})
+	c.deadLetters = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: c.Namespace,
+		Name:      "dead_letters_total",
+		Help:      "Number of events the Watcher moved to the dead-letter sink by action.",
+	}, []string{"action"})
+
+	for _, v := range []prometheus.Collector{
+		c.grants,
+		c.renews,
+		c.revokes,
+		c.expired,
+		c.sweepDuration,
+		c.sweepLag,
+		c.retries,
+		c.failures,
+		c.trimmed,
+		c.trimUndelivered,
+		c.trimPending,
+		c.reaperActive,
+		c.scriptDuration,
+		c.handlerErrors,
+		c.handlerDuration,
+		c.deadLetters,
+	} {
+		if err := c.Registerer.Register(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Collector) OnGrant(sender *lease.Lessor, workspace, leaseID string, ok bool, revision int64, err error) {
+	c.grants.WithLabelValues(workspace, result(err, ok, RESULT_REJECTED)).Inc()
+}
+
+func (c *Collector) OnKeepAlive(sender *lease.Lessor, workspace, leaseID string, expireAt lease.Timestamp, err error) {
+	label := result(err, expireAt > 0, RESULT_MISSING)
+	if err == lease.ErrStaleRenewal {
+		label = RESULT_STALE
+	}
+	c.renews.WithLabelValues(workspace, label).Inc()
+}
+
+func (c *Collector) OnRevoke(sender *lease.Lessor, workspace, leaseID string, ok bool, err error) {
+	c.revokes.WithLabelValues(workspace, result(err, ok, RESULT_REJECTED)).Inc()
+}
+
+func (c *Collector) OnProcess(sender *lease.LeaseReaper, workspace, eventSink string, expireAt time.Time) {
+	c.sweepLag.WithLabelValues(workspace).Observe(time.Since(expireAt).Seconds())
+}
+
+func (c *Collector) OnComplete(sender *lease.LeaseReaper, workspace, eventSink string, count int64, elapsed time.Duration, err error) {
+	c.expired.WithLabelValues(workspace).Add(float64(count))
+	c.sweepDuration.WithLabelValues(workspace).Observe(elapsed.Seconds())
+}
+
+func (c *Collector) OnRecover(sender *lease.LeaseReaper, workspace, eventSink string) {}
+
+func (c *Collector) OnRetry(sender *lease.LeaseReaper, workspace, eventSink string, expireAt time.Time) {
+	c.retries.WithLabelValues(workspace).Inc()
+}
+
+func (c *Collector) OnFailure(sender *lease.LeaseReaper, workspace, eventSink string, policy lease.FailurePolicy, err error) {
+	c.failures.WithLabelValues(workspace, string(policy)).Inc()
+}
+
+func (c *Collector) OnTrim(sender *lease.LeaseReaper, workspace, eventSink string, trim lease.SinkTrimResult) {
+	c.trimmed.WithLabelValues(workspace).Add(float64(trim.Trimmed))
+	for _, group := range trim.UndeliveredGroups {
+		c.trimUndelivered.WithLabelValues(workspace, group).Inc()
+	}
+	for _, group := range trim.PendingGroups {
+		c.trimPending.WithLabelValues(workspace, group).Inc()
+	}
+}
+
+func (c *Collector) OnStart(sender *lease.LeaseReaper) {
+	if sender.IsActive() {
+		c.reaperActive.Set(1)
+	}
+}
+
+func (c *Collector) OnStop(sender *lease.LeaseReaper) {
+	c.reaperActive.Set(0)
+}
+
+func (c *Collector) OnActive(sender *lease.LeaseReaper) {
+	c.reaperActive.Set(1)
+}
+
+func (c *Collector) OnStandby(sender *lease.LeaseReaper) {
+	c.reaperActive.Set(0)
+}
+
+func (c *Collector) OnHandle(sender *lease.Watcher, ev *lease.Event, elapsed time.Duration, err error) {
+	action := string(ev.Action)
+	c.handlerDuration.WithLabelValues(action).Observe(elapsed.Seconds())
+	if err != nil {
+		c.handlerErrors.WithLabelValues(action).Inc()
+	}
+}
+
+func (c *Collector) OnDeadLetter(sender *lease.Watcher, ev *lease.Event, err error) {
+	c.deadLetters.WithLabelValues(string(ev.Action)).Inc()
+}
+
+func (c *Collector) OnExec(script string, elapsed time.Duration, err error) {
+	c.scriptDuration.WithLabelValues(script, result(err, true, "")).Observe(elapsed.Seconds())
+}
+
+func result(err error, ok bool, otherwise string) string {
+	switch {
+	case err != nil:
+		return RESULT_ERROR
+	case ok:
+		return RESULT_OK
+	}
+	return otherwise
+}
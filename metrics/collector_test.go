@@ -0,0 +1,163 @@
+package metrics
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	lease "github.com/bcowtech/lib-redis-lease"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func createTestCollector(t *testing.T) (*Collector, *prometheus.Registry) {
+	registry := prometheus.NewRegistry()
+	c := &Collector{Registerer: registry}
+	if err := c.Init(); err != nil {
+		t.Fatal(err)
+	}
+	return c, registry
+}
+
+// metricValue returns the value of the counter or gauge name with the labels,
+// or zero if it has not been recorded.
+func metricValue(t *testing.T, registry *prometheus.Registry, name string, labels map[string]string) float64 {
+	t.Helper()
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+	next:
+		for _, m := range family.GetMetric() {
+			for _, pair := range m.GetLabel() {
+				if labels[pair.GetName()] != pair.GetValue() {
+					continue next
+				}
+			}
+			if m.Counter != nil {
+				return m.Counter.GetValue()
+			}
+			return m.Gauge.GetValue()
+		}
+	}
+	return 0
+}
+
+func TestCollector_Init(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	c := &Collector{Namespace: "test", Registerer: registry}
+	if err := c.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the metrics are registered only once per registry
+	other := &Collector{Namespace: "test", Registerer: registry}
+	if err := other.Init(); err == nil {
+		t.Errorf("expect an error registering the metrics twice")
+	}
+}
+
+func TestCollector_Lessor(t *testing.T) {
+	c, registry := createTestCollector(t)
+
+	lessor := &lease.Lessor{Store: lease.NewMemoryLeaseStore()}
+	if err := lessor.Init(); err != nil {
+		t.Fatal(err)
+	}
+	lessor.AddHook(c)
+
+	now := time.Now()
+	lessor.Grant("op/lease", lease.Lease{ID: "lease-1", TTL: time.Minute}, now)
+	lessor.Grant("op/lease", lease.Lease{ID: "lease-1", TTL: time.Minute}, now)
+	lessor.KeepAlive("op/lease", "lease-1", now.Add(time.Second))
+	lessor.KeepAlive("op/lease", "lease-1", now.Add(time.Second))
+	lessor.KeepAlive("op/lease", "lease-2", now.Add(time.Second))
+	lessor.Revoke("op/lease", "lease-1")
+	lessor.Revoke("op/lease", "lease-1")
+
+	cases := []struct {
+		name   string
+		result string
+		value  float64
+	}{
+		{"redis_lease_grants_total", RESULT_OK, 1},
+		{"redis_lease_grants_total", RESULT_REJECTED, 1},
+		{"redis_lease_renews_total", RESULT_OK, 1},
+		{"redis_lease_renews_total", RESULT_STALE, 1},
+		{"redis_lease_renews_total", RESULT_MISSING, 1},
+		{"redis_lease_revokes_total", RESULT_OK, 1},
+		{"redis_lease_revokes_total", RESULT_REJECTED, 1},
+	}
+	for _, v := range cases {
+		value := metricValue(t, registry, v.name, map[string]string{"workspace": "op/lease", "result": v.result})
+		if value != v.value {
+			t.Errorf("%s{result=%q}: expect %v, but got %v", v.name, v.result, v.value, value)
+		}
+	}
+}
+
+func TestCollector_KeepAliveError(t *testing.T) {
+	c, registry := createTestCollector(t)
+
+	c.OnKeepAlive(nil, "op/lease", "lease-1", 0, errors.New("the store is unavailable"))
+
+	value := metricValue(t, registry, "redis_lease_renews_total", map[string]string{"workspace": "op/lease", "result": RESULT_ERROR})
+	if value != 1 {
+		t.Errorf("expect %v, but got %v", 1, value)
+	}
+}
+
+func TestCollector_Reaper(t *testing.T) {
+	c, registry := createTestCollector(t)
+
+	c.OnComplete(nil, "op/lease", "op/lease/events", 3, time.Millisecond, nil)
+	c.OnRetry(nil, "op/lease", "op/lease/events", time.Now())
+	c.OnFailure(nil, "op/lease", "op/lease/events", lease.FAILURE_POLICY_RESTART, errors.New("failed"))
+	c.OnTrim(nil, "op/lease", "op/lease/events", lease.SinkTrimResult{
+		Trimmed:           5,
+		UndeliveredGroups: []string{"lagging"},
+		PendingGroups:     []string{"pending"},
+	})
+	c.OnActive(nil)
+
+	cases := []struct {
+		name   string
+		labels map[string]string
+		value  float64
+	}{
+		{"redis_lease_expired_leases_total", map[string]string{"workspace": "op/lease"}, 3},
+		{"redis_lease_sweep_retries_total", map[string]string{"workspace": "op/lease"}, 1},
+		{"redis_lease_sweep_failures_total", map[string]string{"workspace": "op/lease", "policy": "restart"}, 1},
+		{"redis_lease_sink_trimmed_entries_total", map[string]string{"workspace": "op/lease"}, 5},
+		{"redis_lease_sink_trim_undelivered_total", map[string]string{"workspace": "op/lease", "group": "lagging"}, 1},
+		{"redis_lease_sink_trim_pending_total", map[string]string{"workspace": "op/lease", "group": "pending"}, 1},
+		{"redis_lease_reaper_active", nil, 1},
+	}
+	for _, v := range cases {
+		value := metricValue(t, registry, v.name, v.labels)
+		if value != v.value {
+			t.Errorf("%s%v: expect %v, but got %v", v.name, v.labels, v.value, value)
+		}
+	}
+}
+
+func TestCollector_Watcher(t *testing.T) {
+	c, registry := createTestCollector(t)
+
+	ev := &lease.Event{Action: lease.ACTION_EXPIRED}
+	c.OnHandle(nil, ev, time.Millisecond, nil)
+	c.OnHandle(nil, ev, time.Millisecond, errors.New("failed"))
+	c.OnDeadLetter(nil, ev, errors.New("failed"))
+
+	labels := map[string]string{"action": string(lease.ACTION_EXPIRED)}
+	if value := metricValue(t, registry, "redis_lease_handler_errors_total", labels); value != 1 {
+		t.Errorf("handler errors: expect %v, but got %v", 1, value)
+	}
+	if value := metricValue(t, registry, "redis_lease_dead_letters_total", labels); value != 1 {
+		t.Errorf("dead letters: expect %v, but got %v", 1, value)
+	}
+}
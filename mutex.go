@@ -0,0 +1,195 @@
+package lease
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/bcowtech/lib-redis-lease/internal"
+	"github.com/bcowtech/lib-redis-lease/internal/helper"
+)
+
+const (
+	DEFAULT_MUTEX_RETRY_INTERVAL = 50 * time.Millisecond
+)
+
+var (
+	ErrMutexNotHeld = errors.New("the Mutex is not held by the owner")
+)
+
+// Mutex is a distributed lock built on a lease of the Lessor. The lease is
+// kept in the workspace like any other lease, so the LeaseReaper emits an
+// EXPIRED event for a lock whose holder died without unlocking it.
+type Mutex struct {
+	Lessor        *Lessor
+	Workspace     string
+	Key           string
+	TTL           time.Duration
+	RetryInterval time.Duration
+	Metadata      map[string]string
+
+	token    string
+	revision int64
+	expireAt Timestamp
+	mutex    sync.Mutex
+}
+
+// TryLock acquires the lock if it is absent and reports whether it was
+// acquired.
+func (m *Mutex) TryLock(ctx context.Context) (bool, error) {
+	m.validate()
+
+	token, err := helper.RandomToken()
+	if err != nil {
+		return false, err
+	}
+
+	l := m.Lessor
+	if err := l.validateWorkspace(m.Workspace); err != nil {
+		return false, err
+	}
+
+	now := time.Now()
+	ok, revision, err := internal.PutWithRevision(ctx, l.store, m.Workspace, m.Key, m.TTL, now,
+		&LeaseArg{Name: internal.LEASE_ARG_NX, Value: 1},
+		&LeaseArg{Name: internal.LEASE_ARG_OWNER, Value: token},
+		WithMetadata(m.Metadata),
+		l.eventSinkArg(m.Workspace))
+	if err != nil || !ok {
+		return false, err
+	}
+
+	m.mutex.Lock()
+	m.token = token
+	m.revision = revision
+	m.expireAt = Timestamp(now.Add(m.TTL).UnixNano() / int64(time.Millisecond))
+	m.mutex.Unlock()
+	return true, nil
+}
+
+// Lock blocks until the lock is acquired or the ctx is done.
+func (m *Mutex) Lock(ctx context.Context) error {
+	var interval = m.RetryInterval
+	if interval <= 0 {
+		interval = DEFAULT_MUTEX_RETRY_INTERVAL
+	}
+
+	for {
+		ok, err := m.TryLock(ctx)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+		if err := helper.Sleep(ctx, interval); err != nil {
+			return err
+		}
+	}
+}
+
+// KeepAlive extends the lock by its TTL. It returns ErrMutexNotHeld if the
+// lock is no longer held by the owner.
+func (m *Mutex) KeepAlive(ctx context.Context) (Timestamp, error) {
+	m.validate()
+
+	token := m.Token()
+	if len(token) == 0 {
+		return 0, ErrMutexNotHeld
+	}
+
+	l := m.Lessor
+	expireAt, err := l.store.RenewContext(ctx, m.Workspace, m.Key, time.Now(),
+		&LeaseArg{Name: internal.LEASE_ARG_OWNER, Value: token},
+		l.eventSinkArg(m.Workspace))
+	if err != nil && err != ErrStaleRenewal {
+		return 0, err
+	}
+	if expireAt == 0 {
+		return 0, ErrMutexNotHeld
+	}
+
+	m.mutex.Lock()
+	if m.token == token {
+		m.expireAt = expireAt
+	}
+	m.mutex.Unlock()
+	return expireAt, nil
+}
+
+// Unlock releases the lock. It returns ErrMutexNotHeld if the lock is no
+// longer held by the owner.
+func (m *Mutex) Unlock(ctx context.Context) error {
+	m.validate()
+
+	token := m.Token()
+	if len(token) == 0 {
+		return ErrMutexNotHeld
+	}
+
+	l := m.Lessor
+	ok, err := l.store.DeleteContext(ctx, m.Workspace, m.Key,
+		&LeaseArg{Name: internal.LEASE_ARG_OWNER, Value: token},
+		l.eventSinkArg(m.Workspace))
+	if err != nil {
+		return err
+	}
+
+	m.mutex.Lock()
+	if m.token == token {
+		m.token = ""
+		m.revision = 0
+		m.expireAt = 0
+	}
+	m.mutex.Unlock()
+
+	if !ok {
+		return ErrMutexNotHeld
+	}
+	return nil
+}
+
+// expiry returns the expiration time of the lock as of the last successful
+// TryLock or KeepAlive.
+func (m *Mutex) expiry() Timestamp {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.expireAt
+}
+
+// Token returns the owner token of the lock, or an empty string if the lock
+// is not held.
+func (m *Mutex) Token() string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.token
+}
+
+// Revision returns the fencing token issued when the lock was acquired, or 0
+// if the lock is not held or the Store is not a LeaseRevisionStore. Pass it
+// along with the writes guarded by the lock, so the receiver can reject
+// writes from a stale holder with a Fence.
+func (m *Mutex) Revision() int64 {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.revision
+}
+
+func (m *Mutex) validate() {
+	if m.Lessor == nil {
+		logger.Panic("'Lessor' cannot be nil")
+	}
+	if len(m.Workspace) == 0 {
+		logger.Panic("'Workspace' cannot be an empty string")
+	}
+	if len(m.Key) == 0 {
+		logger.Panic("'Key' cannot be an empty string")
+	}
+	if m.TTL <= 0 {
+		logger.Panic("'TTL' must be greater than 0")
+	}
+}
@@ -0,0 +1,140 @@
+package lease
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func createTestMutexes(t *testing.T, store LeaseStore, count int) []*Mutex {
+	lessor := &Lessor{Store: store}
+	if err := lessor.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	mutexes := make([]*Mutex, count)
+	for i := range mutexes {
+		mutexes[i] = &Mutex{
+			Lessor:    lessor,
+			Workspace: "op/mutex",
+			Key:       "lock",
+			TTL:       time.Second,
+		}
+	}
+	return mutexes
+}
+
+func TestMutex_TryLock(t *testing.T) {
+	ctx := context.Background()
+	mutexes := createTestMutexes(t, NewMemoryLeaseStore(), 2)
+	a, b := mutexes[0], mutexes[1]
+
+	ok, err := a.TryLock(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatalf("the lock should be acquired")
+	}
+	if len(a.Token()) == 0 || a.Revision() <= 0 {
+		t.Errorf("expect the token and the revision of the holder, but got %q and %v", a.Token(), a.Revision())
+	}
+	revision := a.Revision()
+
+	// the lock is held by a
+	ok, err = b.TryLock(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Errorf("the lock should not be acquired while held")
+	}
+	if len(b.Token()) != 0 || b.Revision() != 0 {
+		t.Errorf("expect no token and revision, but got %q and %v", b.Token(), b.Revision())
+	}
+
+	if err := a.Unlock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(a.Token()) != 0 || a.Revision() != 0 {
+		t.Errorf("expect no token and revision once unlocked, but got %q and %v", a.Token(), a.Revision())
+	}
+
+	ok, err = b.TryLock(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatalf("the lock should be acquired once unlocked")
+	}
+	if b.Revision() <= revision {
+		t.Errorf("expect a revision greater than %v, but got %v", revision, b.Revision())
+	}
+}
+
+func TestMutex_Unlock_ForeignToken(t *testing.T) {
+	ctx := context.Background()
+	mutexes := createTestMutexes(t, NewMemoryLeaseStore(), 2)
+	a, b := mutexes[0], mutexes[1]
+
+	if ok, err := a.TryLock(ctx); err != nil || !ok {
+		t.Fatalf("the lock should be acquired, but got %v, %v", ok, err)
+	}
+
+	if err := b.Unlock(ctx); err != ErrMutexNotHeld {
+		t.Errorf("expect %v, but got %v", ErrMutexNotHeld, err)
+	}
+	b.token = "foreign"
+	if err := b.Unlock(ctx); err != ErrMutexNotHeld {
+		t.Errorf("expect %v, but got %v", ErrMutexNotHeld, err)
+	}
+
+	// the lock is still held by a
+	if _, err := a.KeepAlive(ctx); err != nil {
+		t.Errorf("the lock should still be held, but got %v", err)
+	}
+	if err := a.Unlock(ctx); err != nil {
+		t.Errorf("the lock should still be held, but got %v", err)
+	}
+}
+
+func TestMutex_KeepAlive_Lost(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryLeaseStore()
+	mutexes := createTestMutexes(t, store, 2)
+	a, b := mutexes[0], mutexes[1]
+
+	if ok, err := a.TryLock(ctx); err != nil || !ok {
+		t.Fatalf("the lock should be acquired, but got %v, %v", ok, err)
+	}
+	expireAt, err := a.KeepAlive(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expireAt == 0 {
+		t.Errorf("expect the expiry of the lock")
+	}
+
+	// the lock expires and is taken over by b
+	_, err = store.ExpireContext(ctx, "op/mutex", "op/mutex/events", time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.KeepAlive(ctx); err != ErrMutexNotHeld {
+		t.Errorf("expect %v, but got %v", ErrMutexNotHeld, err)
+	}
+	if ok, err := b.TryLock(ctx); err != nil || !ok {
+		t.Fatalf("the lock should be taken over, but got %v, %v", ok, err)
+	}
+	if _, err := a.KeepAlive(ctx); err != ErrMutexNotHeld {
+		t.Errorf("expect %v, but got %v", ErrMutexNotHeld, err)
+	}
+	if err := a.Unlock(ctx); err != ErrMutexNotHeld {
+		t.Errorf("expect %v, but got %v", ErrMutexNotHeld, err)
+	}
+
+	// the stale holder leaves the lock of b as is
+	if _, err := b.KeepAlive(ctx); err != nil {
+		t.Errorf("the lock should be held by b, but got %v", err)
+	}
+}
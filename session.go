@@ -0,0 +1,234 @@
+package lease
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/bcowtech/lib-redis-lease/internal/helper"
+)
+
+const (
+	DEFAULT_SESSION_RENEW_RATIO float64 = 1.0 / 3
+	DEFAULT_SESSION_JITTER      float64 = 0.1
+)
+
+var (
+	ErrSessionNotGranted = errors.New("the Session lease is not granted")
+	ErrSessionLost       = errors.New("the Session lease is lost")
+	ErrSessionClosed     = errors.New("the Session is closed")
+)
+
+type KeepAliveResponse struct {
+	LeaseID   string
+	ExpireAt  Timestamp
+	Timestamp Timestamp
+}
+
+// Session grants a lease and keeps it alive in the background. The lease is
+// renewed every RenewInterval, which defaults to a third of the TTL, shortened
+// by a random Jitter so that sessions granted together do not renew together.
+//
+// A failed renewal is retried on the next tick until the lease would have
+// expired. Then, or as soon as the lease is found missing, the Session ends:
+// Done is closed and Err reports the cause.
+type Session struct {
+	Lessor        *Lessor
+	Workspace     string
+	Lease         Lease
+	RenewInterval time.Duration
+	// Fraction of RenewInterval taken off at random, less than 1. Default
+	// is DEFAULT_SESSION_JITTER; a negative value disables jitter.
+	Jitter float64
+
+	revision  int64
+	responses chan KeepAliveResponse
+	done      chan struct{}
+	err       error
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+	mutex     sync.Mutex
+}
+
+// Open grants the lease and starts renewing it. It returns
+// ErrSessionNotGranted if the lease cannot be granted, e.g. it is present
+// with a later timestamp.
+func (s *Session) Open(ctx context.Context) error {
+	s.validate()
+
+	s.mutex.Lock()
+	if s.done != nil {
+		s.mutex.Unlock()
+		logger.Panic("the Session is already opened")
+	}
+	s.responses = make(chan KeepAliveResponse, 1)
+	s.done = make(chan struct{})
+	s.mutex.Unlock()
+
+	now := time.Now()
+	ok, revision, err := s.Lessor.grant(ctx, s.Workspace, s.Lease, now)
+	if err == nil && !ok {
+		err = ErrSessionNotGranted
+	}
+	if err != nil {
+		s.end(err)
+		return err
+	}
+
+	var loopCtx context.Context
+	loopCtx, s.cancel = context.WithCancel(context.Background())
+
+	s.mutex.Lock()
+	s.revision = revision
+	s.mutex.Unlock()
+
+	s.wg.Add(1)
+	go s.renew(loopCtx, Timestamp(now.UnixNano()/int64(time.Millisecond)+s.Lease.TTL.Milliseconds()))
+	return nil
+}
+
+// Close stops renewing and revokes the lease.
+func (s *Session) Close(ctx context.Context) error {
+	s.mutex.Lock()
+	if s.done == nil {
+		s.mutex.Unlock()
+		return nil
+	}
+	s.mutex.Unlock()
+
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.wg.Wait()
+
+	if !s.end(ErrSessionClosed) {
+		return nil
+	}
+	_, err := s.Lessor.RevokeContext(ctx, s.Workspace, s.Lease.ID)
+	return err
+}
+
+// Responses returns the channel of renewal responses. The channel keeps only
+// the latest response if the receiver falls behind, and is closed when the
+// Session ends.
+func (s *Session) Responses() <-chan KeepAliveResponse {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.responses
+}
+
+// Done returns a channel which is closed when the Session ends.
+func (s *Session) Done() <-chan struct{} {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.done
+}
+
+// Err returns the cause of the end of the Session, or nil if it is alive.
+func (s *Session) Err() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.err
+}
+
+// Revision returns the fencing token issued when the lease was granted, or 0
+// if the Store is not a LeaseRevisionStore.
+func (s *Session) Revision() int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.revision
+}
+
+func (s *Session) renew(ctx context.Context, expireAt Timestamp) {
+	defer s.wg.Done()
+
+	for {
+		if err := helper.Sleep(ctx, s.nextInterval()); err != nil {
+			return
+		}
+
+		now := time.Now()
+		value, err := s.Lessor.KeepAliveContext(ctx, s.Workspace, s.Lease.ID, now)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			if now.UnixNano()/int64(time.Millisecond) < int64(expireAt) {
+				continue
+			}
+			s.end(err)
+			return
+		}
+		if value == 0 {
+			s.end(ErrSessionLost)
+			return
+		}
+		expireAt = value
+
+		s.mutex.Lock()
+		select {
+		case <-s.responses:
+		default:
+		}
+		s.responses <- KeepAliveResponse{
+			LeaseID:   s.Lease.ID,
+			ExpireAt:  value,
+			Timestamp: Timestamp(now.UnixNano() / int64(time.Millisecond)),
+		}
+		s.mutex.Unlock()
+	}
+}
+
+func (s *Session) nextInterval() time.Duration {
+	var interval = s.RenewInterval
+	if interval <= 0 {
+		interval = time.Duration(float64(s.Lease.TTL) * DEFAULT_SESSION_RENEW_RATIO)
+	}
+
+	var jitter = s.Jitter
+	switch {
+	case jitter < 0:
+		jitter = 0
+	case jitter == 0:
+		jitter = DEFAULT_SESSION_JITTER
+	}
+	return time.Duration(float64(interval) * (1 - jitter*rand.Float64()))
+}
+
+// end closes the Session with err and reports whether it was not ended yet.
+func (s *Session) end(err error) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.err != nil {
+		return false
+	}
+	s.err = err
+	close(s.responses)
+	close(s.done)
+	return true
+}
+
+func (s *Session) validate() {
+	if s.Lessor == nil {
+		logger.Panic("'Lessor' cannot be nil")
+	}
+	if len(s.Workspace) == 0 {
+		logger.Panic("'Workspace' cannot be an empty string")
+	}
+	if len(s.Lease.ID) == 0 {
+		logger.Panic("'Lease.ID' cannot be an empty string")
+	}
+	if s.Lease.TTL <= 0 {
+		logger.Panic("'Lease.TTL' must be greater than 0")
+	}
+	if s.Jitter >= 1 {
+		logger.Panic("'Jitter' must be less than 1")
+	}
+}
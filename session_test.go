@@ -0,0 +1,162 @@
+package lease
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSession_Renew(t *testing.T) {
+	lessor := createTestLessor(t, NewMemoryLeaseStore())
+	ctx := context.Background()
+
+	s := &Session{
+		Lessor:        lessor,
+		Workspace:     "op/session",
+		Lease:         Lease{ID: "session-1", TTL: 100 * time.Millisecond},
+		RenewInterval: 20 * time.Millisecond,
+	}
+	if err := s.Open(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if s.Revision() == 0 {
+		t.Errorf("Revision should not be 0")
+	}
+
+	var responses int
+	timeout := time.After(300 * time.Millisecond)
+loop:
+	for {
+		select {
+		case resp := <-s.Responses():
+			if resp.LeaseID != "session-1" || resp.ExpireAt <= resp.Timestamp {
+				t.Errorf("unexpected response %+v", resp)
+			}
+			responses++
+		case <-s.Done():
+			t.Fatalf("the Session should be alive, but ended with %v", s.Err())
+		case <-timeout:
+			break loop
+		}
+	}
+	if responses == 0 {
+		t.Errorf("the lease should be renewed")
+	}
+
+	if err := s.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Err(); err != ErrSessionClosed {
+		t.Errorf("expect %v, but got %v", ErrSessionClosed, err)
+	}
+	lease, err := lessor.Lease("op/session", "session-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lease != nil {
+		t.Errorf("the lease should be revoked")
+	}
+}
+
+func TestSession_NotGranted(t *testing.T) {
+	lessor := createTestLessor(t, NewMemoryLeaseStore())
+	ctx := context.Background()
+
+	// present with a later timestamp
+	lessor.Grant("op/session", Lease{ID: "session-1", TTL: time.Second}, time.Now().Add(time.Minute))
+
+	s := &Session{
+		Lessor:    lessor,
+		Workspace: "op/session",
+		Lease:     Lease{ID: "session-1", TTL: time.Second},
+	}
+	if err := s.Open(ctx); err != ErrSessionNotGranted {
+		t.Errorf("expect %v, but got %v", ErrSessionNotGranted, err)
+	}
+}
+
+func TestSession_Lost(t *testing.T) {
+	lessor := createTestLessor(t, NewMemoryLeaseStore())
+	ctx := context.Background()
+
+	s := &Session{
+		Lessor:        lessor,
+		Workspace:     "op/session",
+		Lease:         Lease{ID: "session-1", TTL: time.Second},
+		RenewInterval: 20 * time.Millisecond,
+	}
+	if err := s.Open(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close(ctx)
+
+	lessor.Revoke("op/session", "session-1")
+
+	select {
+	case <-s.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("the Session should end")
+	}
+	if err := s.Err(); err != ErrSessionLost {
+		t.Errorf("expect %v, but got %v", ErrSessionLost, err)
+	}
+}
+
+func TestSession_EndOnTransientErrors(t *testing.T) {
+	store := &faultyLeaseStore{LeaseStore: NewMemoryLeaseStore()}
+	lessor := createTestLessor(t, store)
+	ctx := context.Background()
+
+	s := &Session{
+		Lessor:        lessor,
+		Workspace:     "op/session",
+		Lease:         Lease{ID: "session-1", TTL: 100 * time.Millisecond},
+		RenewInterval: 20 * time.Millisecond,
+	}
+	if err := s.Open(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close(ctx)
+
+	atomic.StoreInt32(&store.failing, 1)
+
+	// retried until the lease would have expired
+	select {
+	case <-s.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("the Session should end")
+	}
+	if err := s.Err(); err != errStoreUnavailable {
+		t.Errorf("expect %v, but got %v", errStoreUnavailable, err)
+	}
+}
+
+func TestSession_NextInterval(t *testing.T) {
+	s := &Session{
+		Lease:         Lease{ID: "session-1", TTL: 3 * time.Second},
+		RenewInterval: time.Second,
+	}
+
+	// default jitter
+	for i := 0; i < 100; i++ {
+		v := s.nextInterval()
+		if v > time.Second || v < time.Duration(float64(time.Second)*(1-DEFAULT_SESSION_JITTER)) {
+			t.Fatalf("interval %v is out of range", v)
+		}
+	}
+
+	// disabled jitter
+	s.Jitter = -1
+	for i := 0; i < 100; i++ {
+		if v := s.nextInterval(); v != time.Second {
+			t.Fatalf("expect %v, but got %v", time.Second, v)
+		}
+	}
+
+	// default interval
+	s.RenewInterval = 0
+	if v := s.nextInterval(); v != time.Second {
+		t.Errorf("expect %v, but got %v", time.Second, v)
+	}
+}
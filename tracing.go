@@ -0,0 +1,63 @@
+package lease
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	TRACER_NAME string = "github.com/bcowtech/lib-redis-lease"
+
+	TRACE_ATTR_WORKSPACE  = attribute.Key("lease.workspace")
+	TRACE_ATTR_LEASE_ID   = attribute.Key("lease.id")
+	TRACE_ATTR_REVISION   = attribute.Key("lease.revision")
+	TRACE_ATTR_EVENT_SINK = attribute.Key("lease.event_sink")
+	TRACE_ATTR_ACTION     = attribute.Key("lease.action")
+	TRACE_ATTR_COUNT      = attribute.Key("lease.count")
+)
+
+var (
+	traceContext = propagation.TraceContext{}
+)
+
+func createTracer(provider trace.TracerProvider) trace.Tracer {
+	if provider == nil {
+		provider = otel.GetTracerProvider()
+	}
+	return provider.Tracer(TRACER_NAME)
+}
+
+// traceParentArg returns the traceparent of the span in ctx as a named
+// argument. The value is nil if ctx carries no valid span.
+func traceParentArg(ctx context.Context) *LeaseArg {
+	carrier := propagation.MapCarrier{}
+	traceContext.Inject(ctx, carrier)
+	return WithTraceParent(carrier.Get("traceparent"))
+}
+
+// traceParentLinks returns a link to the span of the traceparent, if valid.
+func traceParentLinks(traceparent string) []trace.Link {
+	if len(traceparent) == 0 {
+		return nil
+	}
+
+	carrier := propagation.MapCarrier{"traceparent": traceparent}
+	sc := trace.SpanContextFromContext(traceContext.Extract(context.Background(), carrier))
+	if !sc.IsValid() {
+		return nil
+	}
+	return []trace.Link{{SpanContext: sc}}
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,138 @@
+package lease
+
+import (
+	"context"
+	"encoding/binary"
+	"sync"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// testSpan records the name and the links of a span.
+type testSpan struct {
+	trace.Span
+	name        string
+	spanContext trace.SpanContext
+	links       []trace.Link
+}
+
+func (s *testSpan) SpanContext() trace.SpanContext { return s.spanContext }
+func (s *testSpan) IsRecording() bool              { return true }
+
+// testTracerProvider records the spans started by its tracers.
+type testTracerProvider struct {
+	mutex sync.Mutex
+	spans []*testSpan
+}
+
+func (p *testTracerProvider) Tracer(name string, opts ...trace.TracerOption) trace.Tracer {
+	return p
+}
+
+func (p *testTracerProvider) Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	var (
+		config  = trace.NewSpanStartConfig(opts...)
+		traceID trace.TraceID
+		spanID  trace.SpanID
+	)
+	binary.BigEndian.PutUint64(spanID[:], uint64(len(p.spans)+1))
+	if parent := trace.SpanContextFromContext(ctx); parent.IsValid() {
+		traceID = parent.TraceID()
+	} else {
+		binary.BigEndian.PutUint64(traceID[8:], uint64(len(p.spans)+1))
+	}
+
+	span := &testSpan{
+		Span: trace.SpanFromContext(context.Background()),
+		name: name,
+		spanContext: trace.NewSpanContext(trace.SpanContextConfig{
+			TraceID:    traceID,
+			SpanID:     spanID,
+			TraceFlags: trace.FlagsSampled,
+		}),
+		links: config.Links(),
+	}
+	p.spans = append(p.spans, span)
+	return trace.ContextWithSpan(ctx, span), span
+}
+
+func (p *testTracerProvider) find(name string) *testSpan {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for _, span := range p.spans {
+		if span.name == name {
+			return span
+		}
+	}
+	return nil
+}
+
+func TestTracing_TraceParent(t *testing.T) {
+	var (
+		provider = new(testTracerProvider)
+		store    = NewMemoryLeaseStore()
+	)
+	lessor := &Lessor{
+		Store:          store,
+		TracerProvider: provider,
+	}
+	if err := lessor.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the traceparent of the grant is stored with the lease
+	_, err := lessor.Grant("op/trace", Lease{ID: "lease-1", TTL: time.Millisecond}, time.Now().Add(-time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	grant := provider.find("lease.Grant")
+	if grant == nil {
+		t.Fatalf("expect the span %v", "lease.Grant")
+	}
+
+	count, err := store.ExpireContext(context.Background(), "op/trace", "op/trace/events", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Fatalf("expect %v expired leases, but got %v", 1, count)
+	}
+	events := store.Events("op/trace/events")
+	if len(events) != 1 || events[0].Action != ACTION_EXPIRED {
+		t.Fatalf("expect an %v event, but got %v", ACTION_EXPIRED, events)
+	}
+	ev := events[0]
+	if len(ev.TraceParent) == 0 {
+		t.Fatalf("the %v event should carry the traceparent", ACTION_EXPIRED)
+	}
+
+	// the handling span links to the span of the grant
+	w := &Watcher{
+		EventHandler: func(ev *Event) error { return nil },
+	}
+	w.ctx, w.cancel = context.WithCancel(context.Background())
+	defer w.cancel()
+	w.handleCtx = w.ctx
+	w.tracer = createTracer(provider)
+
+	if err := w.invokeEventHandler(ev); err != nil {
+		t.Fatal(err)
+	}
+	handle := provider.find("lease.Handle")
+	if handle == nil {
+		t.Fatalf("expect the span %v", "lease.Handle")
+	}
+	if len(handle.links) != 1 {
+		t.Fatalf("expect %v link, but got %v", 1, len(handle.links))
+	}
+	link := handle.links[0].SpanContext
+	if link.TraceID() != grant.spanContext.TraceID() || link.SpanID() != grant.spanContext.SpanID() {
+		t.Errorf("expect a link to %v, but got %v", grant.spanContext.SpanID(), link.SpanID())
+	}
+}
@@ -1,16 +1,20 @@
 package lease
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"strconv"
 	"strings"
 	"time"
 
 	redis "github.com/bcowtech/lib-redis-stream"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Watcher struct {
 	Group               string
+	GroupStartOffset    string
 	Name                string
 	RedisOption         *RedisOption
 	MaxInFlight         int64
@@ -19,13 +23,100 @@ type Watcher struct {
 	IdlingTimeout       time.Duration
 	ClaimSensitivity    int
 	ClaimOccurrenceRate int32
+	Actions             []EventAction
 	EventHandler        EventHandleProc
 	ErrorHandler        ErrorHandleProc
+	TracerProvider      trace.TracerProvider
+	Logger              Logger
 
-	consumer *redis.Consumer
+	// Retry policy of the EventHandler. A failed event is handled again up
+	// to MaxRetries times, waiting a backoff growing from MinRetryBackoff to
+	// MaxRetryBackoff, or the delay of an EventRetryError. Then it is left
+	// pending until it is claimed again, unless it has been delivered
+	// MaxDeliveries times already, in which case it is dropped. Zero
+	// MaxDeliveries keeps redelivering the event.
+	//
+	// The retries wait on the goroutine handling the event: the delivery
+	// goroutine, or the worker of the lease shard if Concurrency is greater
+	// than 1. No other event is handled by it meanwhile, so keep the total
+	// backoff well below ClaimMinIdleTime, or the event may be claimed by
+	// another consumer while it is being retried.
+	MaxRetries      int
+	MinRetryBackoff time.Duration
+	MaxRetryBackoff time.Duration
+	MaxDeliveries   int64
+
+	// When DeadLetterSink is set, the events delivered MaxDeliveries times
+	// are moved to it with the last handler error instead of being dropped.
+	// See DeadLetterQueue to read and requeue them.
+	DeadLetterSink string
+
+	// Number of workers handling the events in parallel. The events are
+	// sharded by workspace and lease ID, so the events of a lease are still
+	// handled in order, and acknowledged once handled. MaxInFlight bounds
+	// the events queued or being handled. Default is 1, which handles the
+	// events on the delivery goroutine. The EventHandler and the hooks must
+	// be safe for concurrent use if it is greater than 1.
+	Concurrency int
+
+	consumer   *redis.Consumer
+	dispatcher *watcherDispatcher
+	client     RedisClient
+	deliveries func(stream, messageID string) (int64, error)
+	hooks      []WatcherHook
+	tracer     trace.Tracer
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// handleCtx is cancelled by Close before the handlers are waited on, so
+	// the retry backoff does not hold it up
+	handleCtx    context.Context
+	stopHandling context.CancelFunc
 }
 
 func (w *Watcher) Subscribe(streams ...StreamOffset) error {
+	return w.SubscribeContext(context.Background(), streams...)
+}
+
+func (w *Watcher) SubscribeContext(ctx context.Context, streams ...StreamOffset) error {
+	if ctx == nil {
+		logger.Panic("specified argument 'ctx' cannot be nil")
+	}
+	if w.MinRetryBackoff <= 0 {
+		w.MinRetryBackoff = DEFAULT_WATCHER_MIN_RETRY_BACKOFF
+	}
+	if w.MaxRetryBackoff <= 0 {
+		w.MaxRetryBackoff = DEFAULT_WATCHER_MAX_RETRY_BACKOFF
+	}
+	if len(w.DeadLetterSink) > 0 && w.MaxDeliveries <= 0 {
+		logger.Panic("'MaxDeliveries' must be greater than 0 when 'DeadLetterSink' is specified")
+	}
+	if len(w.GroupStartOffset) == 0 {
+		w.GroupStartOffset = GROUP_OFFSET_ZERO
+	}
+
+	if err := w.configRedisConsumerGroup(streams...); err != nil {
+		return err
+	}
+
+	if w.MaxDeliveries > 0 || w.Concurrency > 1 {
+		client, err := CreateRedisUniversalClient(w.RedisOption)
+		if err != nil {
+			return err
+		}
+		w.client = client
+		w.deliveries = w.deliveryCount
+	}
+
+	w.ctx, w.cancel = context.WithCancel(ctx)
+	w.handleCtx, w.stopHandling = context.WithCancel(w.ctx)
+	w.tracer = createTracer(w.TracerProvider)
+
+	if w.Concurrency > 1 {
+		w.dispatcher = newWatcherDispatcher(w.Concurrency, w.MaxInFlight, w.processJob)
+	}
+
 	{
 		consumer := &redis.Consumer{
 			Group:                   w.Group,
@@ -45,101 +136,218 @@ func (w *Watcher) Subscribe(streams ...StreamOffset) error {
 	}
 	c := w.consumer
 
-	w.configRedisConsumerGroup(streams...)
-
 	return c.Subscribe(streams...)
 }
 
 func (w *Watcher) Close() {
+	// stop handling and dispatching first, so no event is handled once the
+	// consumer is closed
+	if w.stopHandling != nil {
+		w.stopHandling()
+	}
+	if w.dispatcher != nil {
+		w.dispatcher.close()
+	}
+	if w.cancel != nil {
+		w.cancel()
+	}
 	if w.consumer != nil {
 		w.consumer.Close()
 	}
+	if w.client != nil {
+		w.client.Close()
+	}
+}
+
+// ResetConsumerGroup moves the last delivered ID of the consumer group on the
+// streams to offset, e.g. GROUP_OFFSET_ZERO to replay the streams. Pending
+// events are kept.
+func (w *Watcher) ResetConsumerGroup(offset string, streams ...string) error {
+	if len(offset) == 0 {
+		logger.Panic("specified argument 'offset' cannot be an empty string")
+	}
+
+	admin, err := redis.NewAdminClient(w.RedisOption)
+	if err != nil {
+		return err
+	}
+	defer admin.Close()
+
+	for _, stream := range streams {
+		if _, err := admin.SetConsumerGroupOffset(stream, w.Group, offset); err != nil {
+			return fmt.Errorf("cannot reset consumer group '%s' of stream '%s': %w", w.Group, stream, err)
+		}
+		resolveLogger(w.Logger).Info("reset the consumer group",
+			LOG_FIELD_EVENT_SINK, stream,
+			LOG_FIELD_GROUP, w.Group,
+			"offset", offset)
+	}
+	return nil
+}
+
+// AddHook adds a hook which is called after each event is handled. The hook
+// may implement WatcherDeadLetterHook as well. It must be called before
+// Subscribe.
+func (w *Watcher) AddHook(hook WatcherHook) {
+	w.hooks = append(w.hooks, hook)
 }
 
 func (w *Watcher) processMessage(ctx *redis.ConsumeContext, stream string, message *redis.XMessage) {
 	ev := &Event{
 		Sink: stream,
+		ctx:  w.handleCtx,
 	}
-	w.fillEventFromMessage(ev, message)
+	fillEventFromMessage(ev, message.ID, message.Values)
 
-	err := w.EventHandler(ev)
-	if err == nil {
+	if !w.isSubscribedAction(ev.Action) {
+		// the entry may be subscribed by the other consumer groups of the sink
+		ctx.Ack(stream, message.ID)
+		return
+	}
+
+	if w.dispatcher != nil {
+		// the message is copied, since it is handled after the MessageHandler
+		// returns; it is left pending if not dispatched, to be claimed again
+		m := *message
+		job := &watcherJob{
+			stream:  stream,
+			message: &m,
+			ev:      ev,
+		}
+		w.dispatcher.dispatch(job, w.handleCtx.Done())
+		return
+	}
+
+	if w.handleEvent(ev, message) {
 		ctx.Ack(stream, message.ID)
 		ctx.Del(stream, message.ID)
 	}
 }
 
-func (w *Watcher) configRedisConsumerGroup(streams ...StreamOffset) error {
-	var (
-		group string = w.Group
-	)
+func (w *Watcher) invokeEventHandler(ev *Event) error {
+	spanCtx, span := w.tracer.Start(w.handleCtx, "lease.Handle",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithLinks(traceParentLinks(ev.TraceParent)...),
+		trace.WithAttributes(
+			TRACE_ATTR_WORKSPACE.String(ev.Workspace),
+			TRACE_ATTR_LEASE_ID.String(ev.LeaseID),
+			TRACE_ATTR_ACTION.String(string(ev.Action)),
+			TRACE_ATTR_REVISION.Int64(ev.Revision),
+			TRACE_ATTR_EVENT_SINK.String(ev.Sink)))
+	ev.ctx = spanCtx
+
+	start := time.Now()
+	err := w.EventHandler(ev)
+	w.triggerOnHandle(ev, time.Since(start), err)
+	endSpan(span, err)
+	if err != nil {
+		resolveLogger(w.Logger).Warn("failed to handle the lease event",
+			LOG_FIELD_WORKSPACE, ev.Workspace,
+			LOG_FIELD_LEASE_ID, ev.LeaseID,
+			LOG_FIELD_EVENT_SINK, ev.Sink,
+			"attempt", ev.Attempt,
+			LOG_FIELD_ERROR, err)
+	}
+	return err
+}
+
+func (w *Watcher) triggerOnHandle(ev *Event, elapsed time.Duration, err error) {
+	for _, h := range w.hooks {
+		h.OnHandle(w, ev, elapsed, err)
+	}
+}
+
+func (w *Watcher) triggerOnDeadLetter(ev *Event, err error) {
+	for _, h := range w.hooks {
+		if h, ok := h.(WatcherDeadLetterHook); ok {
+			h.OnDeadLetter(w, ev, err)
+		}
+	}
+}
+
+func (w *Watcher) isSubscribedAction(action EventAction) bool {
+	if len(w.Actions) == 0 {
+		return true
+	}
+	for _, v := range w.Actions {
+		if v == action {
+			return true
+		}
+	}
+	return false
+}
 
+// configRedisConsumerGroup creates the consumer group on the streams at the
+// GroupStartOffset. An existing group is left as it is.
+func (w *Watcher) configRedisConsumerGroup(streams ...StreamOffset) error {
 	admin, err := redis.NewAdminClient(w.RedisOption)
 	if err != nil {
 		return err
 	}
 	defer admin.Close()
 
-	var handlers = []func(stream string) error{
-		func(stream string) error {
-			_, err := admin.CreateConsumerGroupAndStream(stream, group, redis.StreamZeroOffset)
-			return err
-		},
-		func(stream string) error {
-			_, err := admin.SetConsumerGroupOffset(stream, group, redis.StreamZeroOffset)
-			return err
-		},
-	}
+	return w.createConsumerGroups(admin, streams...)
+}
 
-	for _, offset := range streams {
-		for _, handler := range handlers {
-			err := handler(offset.Stream)
-			if err != nil {
-				switch {
-				case strings.HasPrefix(err.Error(), "BUSYGROUP "):
-					fmt.Printf("%% NOTICE %+v\n", err)
-					break
+func (w *Watcher) createConsumerGroups(admin consumerGroupCreator, streams ...StreamOffset) error {
+	var (
+		group  string = w.Group
+		offset string = w.GroupStartOffset
+	)
 
-				default:
-					return err
-				}
+	for _, v := range streams {
+		_, err := admin.CreateConsumerGroupAndStream(v.Stream, group, offset)
+		if err != nil {
+			if strings.HasPrefix(err.Error(), "BUSYGROUP ") {
+				resolveLogger(w.Logger).Debug("consumer group already exists",
+					LOG_FIELD_EVENT_SINK, v.Stream,
+					LOG_FIELD_GROUP, group)
 				continue
 			}
-			break
+			return fmt.Errorf("cannot create consumer group '%s' of stream '%s': %w", group, v.Stream, err)
 		}
 	}
 	return nil
 }
 
-func (w *Watcher) fillEventFromMessage(ev *Event, src *redis.XMessage) {
+// consumerGroupCreator is the part of redis.AdminClient creating the
+// consumer groups.
+type consumerGroupCreator interface {
+	CreateConsumerGroupAndStream(stream, group, offset string) (string, error)
+}
+
+func fillEventFromMessage(ev *Event, id string, values map[string]interface{}) {
 	var (
-		action    string
-		workspace string
-		leaseID   string
-		exipreAt  Timestamp
-		timestamp Timestamp
+		action      EventAction
+		workspace   string
+		leaseID     string
+		exipreAt    Timestamp
+		timestamp   Timestamp
+		metadata    map[string]string
+		revision    int64
+		traceparent string
 	)
 
 	// action
-	if v, ok := src.Values["action"]; ok {
+	if v, ok := values["action"]; ok {
 		if str, ok := v.(string); ok {
-			action = str
+			action = EventAction(str)
 		}
 	}
 	// workspace
-	if v, ok := src.Values["workspace"]; ok {
+	if v, ok := values["workspace"]; ok {
 		if str, ok := v.(string); ok {
 			workspace = str
 		}
 	}
 	// leaseID
-	if v, ok := src.Values["lease"]; ok {
+	if v, ok := values["lease"]; ok {
 		if str, ok := v.(string); ok {
 			leaseID = str
 		}
 	}
 	// expireAt
-	if v, ok := src.Values["expire_at"]; ok {
+	if v, ok := values["expire_at"]; ok {
 		if str, ok := v.(string); ok {
 			t, err := strconv.ParseInt(str, 10, 64)
 			if err == nil {
@@ -147,9 +355,34 @@ func (w *Watcher) fillEventFromMessage(ev *Event, src *redis.XMessage) {
 			}
 		}
 	}
+	// metadata
+	if v, ok := values["metadata"]; ok {
+		if str, ok := v.(string); ok {
+			var m map[string]string
+			err := json.Unmarshal([]byte(str), &m)
+			if err == nil {
+				metadata = m
+			}
+		}
+	}
+	// revision
+	if v, ok := values["revision"]; ok {
+		if str, ok := v.(string); ok {
+			t, err := strconv.ParseInt(str, 10, 64)
+			if err == nil {
+				revision = t
+			}
+		}
+	}
+	// traceparent
+	if v, ok := values["traceparent"]; ok {
+		if str, ok := v.(string); ok {
+			traceparent = str
+		}
+	}
 	// timestamp
 	{
-		offset := strings.SplitN(src.ID, "-", 2)
+		offset := strings.SplitN(id, "-", 2)
 		if len(offset) > 1 {
 			t, err := strconv.ParseInt(offset[0], 10, 64)
 			if err == nil {
@@ -163,4 +396,7 @@ func (w *Watcher) fillEventFromMessage(ev *Event, src *redis.XMessage) {
 	ev.LeaseID = leaseID
 	ev.ExpireAt = exipreAt
 	ev.Timestamp = timestamp
+	ev.Metadata = metadata
+	ev.Revision = revision
+	ev.TraceParent = traceparent
 }
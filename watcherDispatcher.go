@@ -0,0 +1,131 @@
+package lease
+
+import (
+	"hash/fnv"
+	"sync"
+
+	"github.com/bcowtech/lib-redis-lease/internal/helper"
+	redis "github.com/go-redis/redis/v7"
+)
+
+// watcherJob is an event handled after the MessageHandler returns, so it
+// holds no ConsumeContext; the event is acknowledged through the client of
+// the Watcher.
+type watcherJob struct {
+	stream  string
+	message *XMessage
+	ev      *Event
+}
+
+// watcherDispatcher shards the events by lease over ordered worker queues,
+// so the events of a lease are handled one at a time in delivery order while
+// different leases are handled in parallel. At most maxInFlight events are
+// queued or being handled at once.
+type watcherDispatcher struct {
+	queues   []chan *watcherJob
+	inFlight chan struct{}
+	handle   func(job *watcherJob)
+
+	wg     sync.WaitGroup
+	mutex  sync.RWMutex
+	closed bool
+}
+
+func newWatcherDispatcher(workers int, maxInFlight int64, handle func(job *watcherJob)) *watcherDispatcher {
+	if maxInFlight < int64(workers) {
+		maxInFlight = int64(workers)
+	}
+
+	d := &watcherDispatcher{
+		queues:   make([]chan *watcherJob, workers),
+		inFlight: make(chan struct{}, maxInFlight),
+		handle:   handle,
+	}
+	for i := range d.queues {
+		// the queues never block, since the jobs are bounded by inFlight
+		queue := make(chan *watcherJob, maxInFlight)
+		d.queues[i] = queue
+
+		d.wg.Add(1)
+		go d.work(queue)
+	}
+	return d
+}
+
+// dispatch queues the job, blocking while maxInFlight events are in flight.
+// It returns false if the dispatcher is closed or done is closed meanwhile.
+func (d *watcherDispatcher) dispatch(job *watcherJob, done <-chan struct{}) bool {
+	select {
+	case d.inFlight <- struct{}{}:
+	case <-done:
+		return false
+	}
+
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	if d.closed {
+		<-d.inFlight
+		return false
+	}
+	d.queues[d.shard(job.ev)] <- job
+	return true
+}
+
+// close stops accepting jobs and waits for the jobs being handled. The jobs
+// still queued are discarded, so their events are left pending to be claimed
+// again.
+func (d *watcherDispatcher) close() {
+	d.mutex.Lock()
+	if d.closed {
+		d.mutex.Unlock()
+		return
+	}
+	d.closed = true
+	for _, queue := range d.queues {
+		close(queue)
+	}
+	d.mutex.Unlock()
+
+	d.wg.Wait()
+}
+
+func (d *watcherDispatcher) work(queue chan *watcherJob) {
+	defer d.wg.Done()
+
+	for job := range queue {
+		if !d.isClosed() {
+			d.handle(job)
+		}
+		<-d.inFlight
+	}
+}
+
+func (d *watcherDispatcher) isClosed() bool {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	return d.closed
+}
+
+func (d *watcherDispatcher) shard(ev *Event) int {
+	h := fnv.New32a()
+	h.Write([]byte(ev.Workspace))
+	h.Write([]byte{0})
+	h.Write([]byte(ev.LeaseID))
+	return int(h.Sum32() % uint32(len(d.queues)))
+}
+
+func (w *Watcher) processJob(job *watcherJob) {
+	if !w.handleEvent(job.ev, job.message) {
+		return
+	}
+
+	_, err := helper.WithContext(w.client, w.ctx).Pipelined(func(pipe redis.Pipeliner) error {
+		pipe.XAck(job.stream, w.Group, job.message.ID)
+		pipe.XDel(job.stream, job.message.ID)
+		return nil
+	})
+	if err != nil && w.ErrorHandler != nil {
+		w.ErrorHandler(err)
+	}
+}
@@ -0,0 +1,139 @@
+package lease
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func createTestJob(leaseID string, seq int) *watcherJob {
+	return &watcherJob{
+		stream:  "op/watcher/events",
+		message: &XMessage{ID: fmt.Sprintf("%d-0", seq)},
+		ev:      &Event{Workspace: "op/watcher", LeaseID: leaseID},
+	}
+}
+
+func TestWatcherDispatcher_Ordering(t *testing.T) {
+	const (
+		leases = 5
+		jobs   = 200
+	)
+
+	var (
+		mutex   sync.Mutex
+		handled = make(map[string][]int)
+		wg      sync.WaitGroup
+	)
+	d := newWatcherDispatcher(4, 8, func(job *watcherJob) {
+		defer wg.Done()
+
+		seq, _ := strconv.Atoi(job.message.ID[:len(job.message.ID)-2])
+		time.Sleep(time.Duration(seq%3) * 100 * time.Microsecond)
+
+		mutex.Lock()
+		handled[job.ev.LeaseID] = append(handled[job.ev.LeaseID], seq)
+		mutex.Unlock()
+	})
+
+	wg.Add(jobs)
+	for seq := 0; seq < jobs; seq++ {
+		leaseID := fmt.Sprintf("lease-%d", seq%leases)
+		if !d.dispatch(createTestJob(leaseID, seq), nil) {
+			t.Fatalf("the job should be dispatched")
+		}
+	}
+	wg.Wait()
+	d.close()
+
+	if len(handled) != leases {
+		t.Errorf("expect %v leases, but got %v", leases, len(handled))
+	}
+	for leaseID, seqs := range handled {
+		if len(seqs) != jobs/leases {
+			t.Errorf("%s: expect %v events, but got %v", leaseID, jobs/leases, len(seqs))
+		}
+		for i := 1; i < len(seqs); i++ {
+			if seqs[i] < seqs[i-1] {
+				t.Errorf("%s: the events are handled out of order: %v", leaseID, seqs)
+				break
+			}
+		}
+	}
+}
+
+func TestWatcherDispatcher_MaxInFlight(t *testing.T) {
+	var (
+		release = make(chan struct{})
+		started int32
+	)
+	d := newWatcherDispatcher(2, 3, func(job *watcherJob) {
+		atomic.AddInt32(&started, 1)
+		<-release
+	})
+	defer d.close()
+
+	for seq := 0; seq < 3; seq++ {
+		if !d.dispatch(createTestJob(fmt.Sprintf("lease-%d", seq), seq), nil) {
+			t.Fatalf("the job should be dispatched")
+		}
+	}
+
+	// the 4th job blocks until done is closed
+	done := make(chan struct{})
+	time.AfterFunc(50*time.Millisecond, func() { close(done) })
+	if d.dispatch(createTestJob("lease-3", 3), done) {
+		t.Errorf("the job should not be dispatched while %v jobs are in flight", 3)
+	}
+	if n := atomic.LoadInt32(&started); n > 2 {
+		t.Errorf("expect at most %v jobs handled at once, but got %v", 2, n)
+	}
+
+	close(release)
+	if !d.dispatch(createTestJob("lease-3", 3), nil) {
+		t.Errorf("the job should be dispatched once the jobs in flight are handled")
+	}
+}
+
+func TestWatcherDispatcher_Close(t *testing.T) {
+	var (
+		release = make(chan struct{})
+		started = make(chan struct{}, 3)
+		handled int32
+	)
+	d := newWatcherDispatcher(1, 3, func(job *watcherJob) {
+		started <- struct{}{}
+		<-release
+		atomic.AddInt32(&handled, 1)
+	})
+
+	for seq := 0; seq < 3; seq++ {
+		d.dispatch(createTestJob("lease-1", seq), nil)
+	}
+	<-started
+
+	closed := make(chan struct{})
+	go func() {
+		d.close()
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+		t.Fatalf("close should wait for the job being handled")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+	<-closed
+
+	// the queued jobs are discarded
+	if n := atomic.LoadInt32(&handled); n != 1 {
+		t.Errorf("expect %v handled jobs, but got %v", 1, n)
+	}
+	if d.dispatch(createTestJob("lease-1", 3), nil) {
+		t.Errorf("the job should not be dispatched once closed")
+	}
+}
@@ -0,0 +1,159 @@
+package lease
+
+import (
+	"errors"
+	"time"
+
+	"github.com/bcowtech/lib-redis-lease/internal/helper"
+	redis "github.com/go-redis/redis/v7"
+)
+
+const (
+	DEFAULT_WATCHER_MIN_RETRY_BACKOFF time.Duration = 100 * time.Millisecond
+	DEFAULT_WATCHER_MAX_RETRY_BACKOFF time.Duration = 10 * time.Second
+)
+
+var (
+	_ error = new(EventRetryError)
+	_ error = new(EventDropError)
+)
+
+// EventRetryError is returned by an EventHandler to handle the event again
+// after Delay. A zero Delay uses the retry backoff of the Watcher.
+type EventRetryError struct {
+	Delay time.Duration
+	Err   error
+}
+
+func (e *EventRetryError) Error() string {
+	if e.Err == nil {
+		return "retry the event"
+	}
+	return e.Err.Error()
+}
+
+func (e *EventRetryError) Unwrap() error { return e.Err }
+
+// EventDropError is returned by an EventHandler to acknowledge an event it
+// cannot handle, without retrying it.
+type EventDropError struct {
+	Err error
+}
+
+func (e *EventDropError) Error() string {
+	if e.Err == nil {
+		return "drop the event"
+	}
+	return e.Err.Error()
+}
+
+func (e *EventDropError) Unwrap() error { return e.Err }
+
+func RetryEvent(delay time.Duration, err error) error {
+	return &EventRetryError{Delay: delay, Err: err}
+}
+
+func DropEvent(err error) error {
+	return &EventDropError{Err: err}
+}
+
+// handleEvent calls the EventHandler under the retry policy and reports
+// whether the message is done with and can be acknowledged. The retry
+// backoff blocks the calling goroutine.
+func (w *Watcher) handleEvent(ev *Event, message *XMessage) bool {
+	var (
+		maxRetries      = w.MaxRetries
+		minRetryBackoff = w.MinRetryBackoff
+		maxRetryBackoff = w.MaxRetryBackoff
+		lastErr         error
+	)
+
+	for attempt := 0; ; attempt++ {
+		ev.Attempt = attempt + 1
+
+		err := w.invokeEventHandler(ev)
+		lastErr = err
+		if err == nil {
+			return true
+		}
+
+		var drop *EventDropError
+		if errors.As(err, &drop) {
+			resolveLogger(w.Logger).Warn("dropped the lease event",
+				LOG_FIELD_WORKSPACE, ev.Workspace,
+				LOG_FIELD_LEASE_ID, ev.LeaseID,
+				LOG_FIELD_EVENT_SINK, ev.Sink,
+				LOG_FIELD_ERROR, err)
+			return true
+		}
+		if attempt >= maxRetries {
+			break
+		}
+
+		delay := helper.RetryBackoff(attempt, minRetryBackoff, maxRetryBackoff)
+		var retry *EventRetryError
+		if errors.As(err, &retry) && retry.Delay > 0 {
+			delay = retry.Delay
+		}
+		if err := helper.Sleep(w.handleCtx, delay); err != nil {
+			return false
+		}
+	}
+
+	// the retries are exhausted; leave the message pending for redelivery
+	// unless it has been delivered too many times
+	if w.MaxDeliveries > 0 {
+		deliveries, err := w.deliveries(ev.Sink, message.ID)
+		if err != nil {
+			if w.ErrorHandler != nil {
+				w.ErrorHandler(err)
+			}
+			return false
+		}
+		if deliveries >= w.MaxDeliveries && len(w.DeadLetterSink) > 0 {
+			if err := w.deadLetter(ev, message, deliveries, lastErr); err != nil {
+				if w.ErrorHandler != nil {
+					w.ErrorHandler(err)
+				}
+				return false
+			}
+			resolveLogger(w.Logger).Warn("moved the lease event to the dead-letter sink",
+				LOG_FIELD_WORKSPACE, ev.Workspace,
+				LOG_FIELD_LEASE_ID, ev.LeaseID,
+				LOG_FIELD_EVENT_SINK, ev.Sink,
+				"dead_letter_sink", w.DeadLetterSink,
+				"deliveries", deliveries,
+				LOG_FIELD_ERROR, lastErr)
+			w.triggerOnDeadLetter(ev, lastErr)
+			return true
+		}
+		if deliveries >= w.MaxDeliveries {
+			resolveLogger(w.Logger).Warn("dropped the lease event delivered too many times",
+				LOG_FIELD_WORKSPACE, ev.Workspace,
+				LOG_FIELD_LEASE_ID, ev.LeaseID,
+				LOG_FIELD_EVENT_SINK, ev.Sink,
+				"deliveries", deliveries)
+			return true
+		}
+	}
+	return false
+}
+
+// deliveryCount returns the number of times the pending message was
+// delivered to the consumer group.
+func (w *Watcher) deliveryCount(stream, messageID string) (int64, error) {
+	pending, err := w.client.XPendingExt(&redis.XPendingExtArgs{
+		Stream: stream,
+		Group:  w.Group,
+		Start:  messageID,
+		End:    messageID,
+		Count:  1,
+	}).Result()
+	if err != nil {
+		return 0, err
+	}
+	if len(pending) == 0 {
+		return 0, nil
+	}
+	return pending[0].RetryCount, nil
+}
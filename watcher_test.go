@@ -0,0 +1,320 @@
+package lease
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	redis "github.com/go-redis/redis/v7"
+)
+
+var errHandleEvent = errors.New("cannot handle the event")
+
+// deadLetterClient records the entries appended to the dead-letter sink.
+type deadLetterClient struct {
+	RedisClient
+	added []*redis.XAddArgs
+}
+
+func (c *deadLetterClient) XAdd(a *redis.XAddArgs) *redis.StringCmd {
+	c.added = append(c.added, a)
+	return redis.NewStringResult("1-0", nil)
+}
+
+type deadLetterRecorder struct {
+	letters []*Event
+}
+
+func (h *deadLetterRecorder) OnHandle(sender *Watcher, ev *Event, elapsed time.Duration, err error) {}
+
+func (h *deadLetterRecorder) OnDeadLetter(sender *Watcher, ev *Event, err error) {
+	h.letters = append(h.letters, ev)
+}
+
+func createTestWatcher(maxRetries int, handler EventHandleProc) *Watcher {
+	w := &Watcher{
+		Group:           "group",
+		EventHandler:    handler,
+		Logger:          NopLogger{},
+		MaxRetries:      maxRetries,
+		MinRetryBackoff: time.Millisecond,
+		MaxRetryBackoff: time.Millisecond,
+	}
+	w.ctx, w.cancel = context.WithCancel(context.Background())
+	w.handleCtx, w.stopHandling = context.WithCancel(w.ctx)
+	w.tracer = createTracer(nil)
+	return w
+}
+
+func createTestEvent() (*Event, *XMessage) {
+	message := &XMessage{
+		ID: "1-0",
+		Values: map[string]interface{}{
+			"action":    string(ACTION_EXPIRED),
+			"workspace": "op/watcher",
+			"lease":     "lease-1",
+		},
+	}
+	ev := &Event{Sink: "op/watcher/events"}
+	fillEventFromMessage(ev, message.ID, message.Values)
+	return ev, message
+}
+
+func TestWatcher_HandleEvent_Retry(t *testing.T) {
+	var calls int
+	w := createTestWatcher(3, func(ev *Event) error {
+		calls++
+		if ev.Attempt != calls {
+			t.Errorf("expect attempt %v, but got %v", calls, ev.Attempt)
+		}
+		if calls < 3 {
+			return errHandleEvent
+		}
+		return nil
+	})
+	defer w.cancel()
+
+	ev, message := createTestEvent()
+	if !w.handleEvent(ev, message) {
+		t.Errorf("the event should be acknowledged")
+	}
+	if calls != 3 {
+		t.Errorf("expect %v calls, but got %v", 3, calls)
+	}
+}
+
+func TestWatcher_HandleEvent_RetryDelay(t *testing.T) {
+	var calls int
+	w := createTestWatcher(1, func(ev *Event) error {
+		calls++
+		if calls == 1 {
+			return RetryEvent(50*time.Millisecond, errHandleEvent)
+		}
+		return nil
+	})
+	defer w.cancel()
+
+	ev, message := createTestEvent()
+	start := time.Now()
+	if !w.handleEvent(ev, message) {
+		t.Errorf("the event should be acknowledged")
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("the retry should wait the delay of the EventRetryError, but took %v", elapsed)
+	}
+}
+
+func TestWatcher_HandleEvent_Drop(t *testing.T) {
+	var calls int
+	w := createTestWatcher(3, func(ev *Event) error {
+		calls++
+		return DropEvent(errHandleEvent)
+	})
+	defer w.cancel()
+
+	ev, message := createTestEvent()
+	if !w.handleEvent(ev, message) {
+		t.Errorf("the dropped event should be acknowledged")
+	}
+	if calls != 1 {
+		t.Errorf("expect %v calls, but got %v", 1, calls)
+	}
+}
+
+func TestWatcher_HandleEvent_RetriesExhausted(t *testing.T) {
+	var calls int
+	w := createTestWatcher(2, func(ev *Event) error {
+		calls++
+		return errHandleEvent
+	})
+	defer w.cancel()
+
+	ev, message := createTestEvent()
+	if w.handleEvent(ev, message) {
+		t.Errorf("the event should be left pending")
+	}
+	if calls != 3 {
+		t.Errorf("expect %v calls, but got %v", 3, calls)
+	}
+}
+
+func TestWatcher_HandleEvent_Closed(t *testing.T) {
+	w := createTestWatcher(3, func(ev *Event) error {
+		return RetryEvent(time.Minute, errHandleEvent)
+	})
+	w.cancel()
+
+	ev, message := createTestEvent()
+	if w.handleEvent(ev, message) {
+		t.Errorf("the event should be left pending")
+	}
+}
+
+func TestWatcher_Close_RetryBackoff(t *testing.T) {
+	started := make(chan struct{}, 1)
+	w := createTestWatcher(3, func(ev *Event) error {
+		started <- struct{}{}
+		return RetryEvent(time.Minute, errHandleEvent)
+	})
+	w.dispatcher = newWatcherDispatcher(2, 2, w.processJob)
+
+	ev, message := createTestEvent()
+	job := &watcherJob{stream: ev.Sink, message: message, ev: ev}
+	if !w.dispatcher.dispatch(job, nil) {
+		t.Fatalf("the job should be dispatched")
+	}
+	<-started
+
+	// the handler waits its retry backoff until the watcher is closed
+	closed := make(chan struct{})
+	go func() {
+		w.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatalf("close should interrupt the retry backoff of the handlers")
+	}
+}
+
+func TestWatcher_HandleEvent_MaxDeliveries(t *testing.T) {
+	cases := []struct {
+		name          string
+		deliveries    int64
+		err           error
+		sink          string
+		expectAck     bool
+		expectLetters int
+	}{
+		{"pending", 2, nil, "", false, 0},
+		{"dropped", 3, nil, "", true, 0},
+		{"dead-lettered", 3, nil, "op/watcher/dead-letters", true, 1},
+		{"unknown deliveries", 0, errStoreUnavailable, "op/watcher/dead-letters", false, 0},
+	}
+
+	for _, c := range cases {
+		var (
+			client = new(deadLetterClient)
+			hook   = new(deadLetterRecorder)
+			errs   []error
+		)
+		w := createTestWatcher(0, func(ev *Event) error {
+			return errHandleEvent
+		})
+		w.MaxDeliveries = 3
+		w.DeadLetterSink = c.sink
+		w.ErrorHandler = func(err error) bool {
+			errs = append(errs, err)
+			return true
+		}
+		w.client = client
+		w.deliveries = func(stream, messageID string) (int64, error) {
+			return c.deliveries, c.err
+		}
+		w.AddHook(hook)
+
+		ev, message := createTestEvent()
+		if ack := w.handleEvent(ev, message); ack != c.expectAck {
+			t.Errorf("%s: expect %v, but got %v", c.name, c.expectAck, ack)
+		}
+		if len(client.added) != c.expectLetters || len(hook.letters) != c.expectLetters {
+			t.Errorf("%s: expect %v dead letters, but got %v appended and %v reported", c.name, c.expectLetters, len(client.added), len(hook.letters))
+		}
+		if c.err != nil && (len(errs) != 1 || errs[0] != c.err) {
+			t.Errorf("%s: expect ErrorHandler to get %v, but got %v", c.name, c.err, errs)
+		}
+		w.cancel()
+	}
+
+	// the dead letter keeps the original entry and the cause
+	client := new(deadLetterClient)
+	w := createTestWatcher(0, func(ev *Event) error {
+		return errHandleEvent
+	})
+	defer w.cancel()
+	w.MaxDeliveries = 1
+	w.DeadLetterSink = "op/watcher/dead-letters"
+	w.client = client
+	w.deliveries = func(stream, messageID string) (int64, error) { return 1, nil }
+
+	ev, message := createTestEvent()
+	w.handleEvent(ev, message)
+	if len(client.added) != 1 {
+		t.Fatalf("expect %v dead letters, but got %v", 1, len(client.added))
+	}
+	args := client.added[0]
+	if args.Stream != w.DeadLetterSink {
+		t.Errorf("expect %v, but got %v", w.DeadLetterSink, args.Stream)
+	}
+	values := args.Values
+	expected := map[string]interface{}{
+		"lease":                     "lease-1",
+		DEAD_LETTER_FIELD_SOURCE:    "op/watcher/events",
+		DEAD_LETTER_FIELD_SOURCE_ID: "1-0",
+		DEAD_LETTER_FIELD_GROUP:     "group",
+		DEAD_LETTER_FIELD_ERROR:     errHandleEvent.Error(),
+	}
+	for k, v := range expected {
+		if values[k] != v {
+			t.Errorf("%s: expect %v, but got %v", k, v, values[k])
+		}
+	}
+}
+
+// consumerGroupAdmin fails to create the groups of the streams in errs.
+type consumerGroupAdmin struct {
+	errs    map[string]error
+	created []string
+}
+
+func (a *consumerGroupAdmin) CreateConsumerGroupAndStream(stream, group, offset string) (string, error) {
+	if err := a.errs[stream]; err != nil {
+		return "", err
+	}
+	a.created = append(a.created, stream+"@"+offset)
+	return "OK", nil
+}
+
+func TestWatcher_CreateConsumerGroups(t *testing.T) {
+	var (
+		errBusyGroup = errors.New("BUSYGROUP Consumer Group name already exists")
+		errWrongType = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+	)
+	w := &Watcher{
+		Group:            "group",
+		GroupStartOffset: GROUP_OFFSET_LATEST,
+		Logger:           NopLogger{},
+	}
+
+	// an existing group is left as it is
+	admin := &consumerGroupAdmin{
+		errs: map[string]error{"op/b/events": errBusyGroup},
+	}
+	err := w.createConsumerGroups(admin,
+		StreamOffset{Stream: "op/a/events"},
+		StreamOffset{Stream: "op/b/events"},
+		StreamOffset{Stream: "op/c/events"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"op/a/events@$", "op/c/events@$"}
+	if len(admin.created) != len(expected) || admin.created[0] != expected[0] || admin.created[1] != expected[1] {
+		t.Errorf("expect %v, but got %v", expected, admin.created)
+	}
+
+	// the other errors stop the creation
+	admin = &consumerGroupAdmin{
+		errs: map[string]error{"op/a/events": errWrongType},
+	}
+	err = w.createConsumerGroups(admin,
+		StreamOffset{Stream: "op/a/events"},
+		StreamOffset{Stream: "op/b/events"})
+	if !errors.Is(err, errWrongType) {
+		t.Errorf("expect %v, but got %v", errWrongType, err)
+	}
+	if len(admin.created) != 0 {
+		t.Errorf("expect no group created, but got %v", admin.created)
+	}
+}